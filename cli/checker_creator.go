@@ -0,0 +1,7 @@
+package cli
+
+import (
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+type CheckerCreator func(isIgnoreSSLCertificates bool, policy *afd.DestinationPolicy) afd.CheckFunc
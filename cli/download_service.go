@@ -1,25 +1,91 @@
 package cli
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+	"github.com/morozovcookie/afifiledownloader/tcp"
+	"github.com/morozovcookie/afifiledownloader/transform"
+)
+
+var ErrOutputsUnavailable = errors.New("stream error: all outputs are unavailable")
+
+const (
+	BodyEncodingText   = "utf-8"
+	BodyEncodingBase64 = "base64"
 )
 
 type DownloadService struct {
 	dc DownloaderCreator
 	sc StreamerCreator
+	cc CheckerCreator
+
+	lookupHost func(ctx context.Context, host string) (addrs []string, err error)
+
+	policy *afd.DestinationPolicy
+
+	globalReadBucket  *transform.Bucket
+	globalWriteBucket *transform.Bucket
+
+	execCommands []string
+	execArgs     []string
+	execEnv      map[string]string
+	execDir      string
 }
 
-func NewDownloadService(dc DownloaderCreator, sc StreamerCreator) *DownloadService {
+func NewDownloadService(dc DownloaderCreator, sc StreamerCreator, cc CheckerCreator) *DownloadService {
 	return &DownloadService{
 		dc: dc,
 		sc: sc,
+		cc: cc,
+
+		lookupHost: net.DefaultResolver.LookupHost,
+
+		policy: &afd.DestinationPolicy{IsBlockPrivate: true},
 	}
 }
 
-func (svc *DownloadService) Download(r io.Reader) (err error) {
+// SetDestinationPolicy sets the policy which the addresses of the source are checked with. The service blocks
+// private and reserved addresses by default, so the input could not reach internal services. Nil policy allows every
+// address. The input could not change the policy.
+func (svc *DownloadService) SetDestinationPolicy(policy *afd.DestinationPolicy) {
+	svc.policy = policy
+}
+
+// SetGlobalLimits sets rate limits which are shared by all downloads of the service, so concurrent downloads do not
+// exceed them together. Nil bucket means no limit.
+func (svc *DownloadService) SetGlobalLimits(read, write *transform.Bucket) {
+	svc.globalReadBucket, svc.globalWriteBucket = read, write
+}
+
+// SetExecCommands sets the commands which exec outputs could run. Exec outputs are rejected until it is called.
+func (svc *DownloadService) SetExecCommands(commands []string) {
+	svc.execCommands = commands
+}
+
+// SetExecOptions sets the arguments, the variables which are added to the environment and the working directory of
+// the commands of exec outputs. The input could not set them.
+func (svc *DownloadService) SetExecOptions(args []string, env map[string]string, dir string) {
+	svc.execArgs, svc.execEnv, svc.execDir = args, env, dir
+}
+
+func (svc *DownloadService) Download(r io.Reader, out *Output) (err error) {
 	in := &Input{
 		MaxRedirects: DefaultMaxRedirects,
 		Timeout:      DefaultTimeout,
@@ -29,36 +95,605 @@ func (svc *DownloadService) Download(r io.Reader) (err error) {
 		return err
 	}
 
+	in.ExecCommands = svc.execCommands
+
 	if err = in.Validate(); err != nil {
 		return err
 	}
 
+	in.ExecArgs, in.ExecEnv, in.ExecDir = svc.execArgs, svc.execEnv, svc.execDir
+
+	if in.URL, err = NormalizeURL(in.URL, in.URLSchemes); err != nil {
+		return err
+	}
+
+	policy := svc.policy
+
+	if in.RequestID == "" {
+		if in.RequestID, err = newRequestID(); err != nil {
+			return err
+		}
+	}
+
+	out.RequestID = in.RequestID
+
+	var s afd.Streamer
+
+	defer func() {
+		if s != nil {
+			_ = s.Close()
+		}
+	}()
+
+	// The receiver of the listen output is awaited before the request is sent, otherwise the connection to the source
+	// idles or times out while the receiver connects.
+	if in.IsPreflight || in.IsDryRun || strings.HasPrefix(in.Output, tcp.ListenScheme) {
+		if s, err = svc.preflight(in, policy, out); err != nil {
+			return err
+		}
+
+		if in.IsDryRun {
+			return nil
+		}
+	}
+
 	callback := func(res *http.Response) (err error) {
 		defer res.Body.Close()
 
-		if in.Output == "" {
+		var (
+			body = io.Reader(res.Body)
+			m    = metadata(res, in)
+		)
+
+		if buckets := buckets(in.ReadRateLimit, in.ReadRateBurst, svc.globalReadBucket); len(buckets) > 0 {
+			body = transform.NewLimitedReader(context.Background(), body, buckets...)
+		}
+
+		if in.IsDecode {
+			var d *transform.Decoder
+			if d, out.Decoding, err = decode(res, body, &m); err != nil {
+				return err
+			}
+
+			defer d.Close()
+
+			body = d
+		}
+
+		if in.Output == "" && in.Extract == "" {
+			if in.IsInlineBody {
+				return inline(body, in.InlineBodyMaxSize, out)
+			}
+
 			return nil
 		}
 
-		s, err := svc.sc(in.Output)
+		if in.Extract != "" {
+			// The streamer of the preflight is handed over to the first entry which goes to the output.
+			first := s
+			s = nil
+
+			return svc.extract(in, body, m, first, out)
+		}
+
+		if in.SplitSize > 0 {
+			var splitter *transform.Splitter
+			if splitter, err = svc.splitter(in, s, out); err != nil {
+				return err
+			}
+
+			// The manifest lists the delivered chunks even if the download failed.
+			defer func() { out.Chunks = chunkReports(splitter.Chunks()) }()
+
+			s = splitter
+		}
+
+		if s == nil {
+			if s, out.Output, err = svc.createStreamer(in.Outputs(), in.OutputOptions); err != nil {
+				return err
+			}
+		}
+
+		var (
+			compressor *transform.Compressor
+			encryptor  *transform.Encryptor
+		)
+
+		if buckets := buckets(in.WriteRateLimit, in.WriteRateBurst, svc.globalWriteBucket); len(buckets) > 0 {
+			s = transform.NewLimiter(context.Background(), s, buckets...)
+		}
+
+		// The data is compressed before encryption, because encrypted data could not be compressed.
+		if len(in.EncryptionRecipients) > 0 {
+			if encryptor, err = transform.NewEncryptor(s, in.EncryptionRecipients); err != nil {
+				return err
+			}
+
+			s = encryptor
+		}
+
+		if in.Compression != "" {
+			if compressor, err = transform.NewCompressor(s, in.Compression, in.CompressionLevel); err != nil {
+				return err
+			}
+
+			s = compressor
+		}
+
+		if hw, ok := s.(afd.HeaderWriter); ok {
+			if err = hw.WriteHeader(m); err != nil {
+				return err
+			}
+		}
+
+		if in.RingBufferSize > 0 {
+			err = copyBuffered(s, body, res.Body, in, out)
+		} else {
+			_, err = copyBody(s, body, in.BufferSize)
+		}
+
 		if err != nil {
 			return err
 		}
 
-		defer s.Close()
+		if f, ok := s.(afd.Finisher); ok {
+			if err = f.Finish(); err != nil {
+				return err
+			}
+		}
 
-		if _, err = io.Copy(s, res.Body); err != nil {
-			return err
+		if r, ok := s.(afd.Reporter); ok {
+			out.OutputReport = r.Report()
+		}
+
+		if compressor != nil {
+			stats := compressor.Stats()
+			out.Compression = &CompressionReport{
+				Algorithm:       in.Compression,
+				RawBytes:        stats.RawBytes,
+				CompressedBytes: stats.CompressedBytes,
+				IsSkipped:       stats.IsSkipped,
+			}
+		}
+
+		if encryptor != nil {
+			stats := encryptor.Stats()
+			out.Encryption = &EncryptionReport{
+				Recipients:     len(in.EncryptionRecipients),
+				RawBytes:       stats.RawBytes,
+				EncryptedBytes: stats.EncryptedBytes,
+			}
 		}
 
 		return nil
 	}
 
-	err = svc.dc(in.IsFollowRedirects, in.MaxRedirects, in.IsIgnoreSSLCertificates)(
-		in.URL, time.Duration(in.Timeout), callback)
+	// Redirect locations are checked with the same schemes as the url.
+	schemes := in.URLSchemes
+	if len(schemes) == 0 {
+		schemes = DefaultURLSchemes
+	}
+
+	err = svc.dc(in.IsFollowRedirects, in.MaxRedirects, in.IsIgnoreSSLCertificates, in.AcceptEncoding, in.ZipEntry,
+		schemes, policy)(in.URL, time.Duration(in.Timeout), callback)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// preflight checks that the source host could be resolved into the address allowed by the policy, the output could
+// be dialed and, optionally, the source answers a HEAD request. The dialed streamer is returned so that the download
+// could reuse it.
+func (svc *DownloadService) preflight(
+	in *Input,
+	policy *afd.DestinationPolicy,
+	out *Output,
+) (
+	s afd.Streamer,
+	err error,
+) {
+	u, err := url.Parse(in.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	out.Preflight = &PreflightReport{SourceHost: u.Hostname()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(in.Timeout))
+	defer cancel()
+
+	if out.Preflight.SourceAddresses, err = svc.lookupHost(ctx, u.Hostname()); err != nil {
+		return nil, err
+	}
+
+	if err = checkAddresses(policy, out.Preflight.SourceAddresses); err != nil {
+		return nil, err
+	}
+
+	// The dry run does not wait for the receiver of the listen output, it would block for listen-timeout, the
+	// output is reported as selected.
+	if in.IsDryRun && strings.HasPrefix(in.Output, tcp.ListenScheme) {
+		out.Preflight.Output, out.Output = in.Output, in.Output
+	} else if in.Output != "" {
+		if s, out.Preflight.Output, err = svc.createStreamer(in.Outputs(), in.OutputOptions); err != nil {
+			return nil, err
+		}
+
+		out.Output = out.Preflight.Output
+	}
+
+	if !in.IsPreflightHead {
+		return s, nil
+	}
+
+	out.Preflight.HTTPCode, out.Preflight.ContentLength, out.Preflight.ContentType, err = svc.cc(
+		in.IsIgnoreSSLCertificates, policy)(in.URL, time.Duration(in.Timeout))
+	if err != nil {
+		if s != nil {
+			_ = s.Close()
+		}
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// checkAddresses returns the error if all addresses are blocked by the policy, the download could not connect to the
+// source then.
+func checkAddresses(policy *afd.DestinationPolicy, addrs []string) (err error) {
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if err = policy.Check(net.ParseIP(addrs[i])); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// createStreamer tries outputs one by one and returns the streamer of the first one which could be dialed.
+func (svc *DownloadService) createStreamer(
+	outputs []string,
+	opts OutputOptions,
+) (
+	s afd.Streamer,
+	address string,
+	err error,
+) {
+	for _, address = range outputs {
+		if s, err = svc.sc(address, opts); err == nil {
+			return s, address, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("%w: %v", ErrOutputsUnavailable, err)
+}
+
+// extract routes every entry of the archive to the output of the first matching rule or, if no rule matches, to the
+// output of the input. The entry is skipped if there is no output for it.
+func (svc *DownloadService) extract(in *Input, body io.Reader, m afd.Metadata, first afd.Streamer, out *Output) error {
+	defer func() {
+		if first != nil {
+			_ = first.Close()
+		}
+	}()
+
+	var (
+		format     = in.Extract
+		filePath   string
+		writeLimit = buckets(in.WriteRateLimit, in.WriteRateBurst, svc.globalWriteBucket)
+	)
+
+	if u, err := url.Parse(m.SourceURL); err == nil {
+		filePath = u.Path
+	}
+
+	if format == ExtractAuto {
+		format = transform.ArchiveFormat(m.ContentType, filePath)
+	}
+
+	return transform.Extract(format, body, "", func(e transform.ArchiveEntry, r io.Reader) (err error) {
+		outputs := in.Outputs()
+
+		for _, rule := range in.ExtractRules {
+			if rule.Match(e.Name) {
+				outputs = []string{rule.Output}
+
+				break
+			}
+		}
+
+		report := EntryReport{Name: e.Name, Size: e.Size}
+
+		if len(outputs) > 0 {
+			var s afd.Streamer
+
+			if outputs[0] == in.Output && first != nil {
+				s, report.Output, first = first, out.Output, nil
+			} else if s, report.Output, err = svc.createStreamer(outputs, in.OutputOptions); err != nil {
+				return err
+			}
+
+			if len(writeLimit) > 0 {
+				s = transform.NewLimiter(context.Background(), s, writeLimit...)
+			}
+
+			m := m
+			m.FileName, m.ContentLength, m.ContentEncoding = e.Name, e.Size, ""
+			m.ContentType = mime.TypeByExtension(path.Ext(e.Name))
+
+			report.Size, report.OutputReport, err = stream(s, r, m, in.BufferSize)
+		}
+
+		out.Entries = append(out.Entries, report)
+
+		return err
+	})
+}
+
+// inline puts up to maxSize bytes of the body into the output, as is if it is UTF-8 text, otherwise encoded into
+// base64. Zero maxSize means the default max size.
+func inline(body io.Reader, maxSize int64, out *Output) (err error) {
+	if maxSize == 0 {
+		maxSize = DefaultInlineBodyMaxSize
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxSize+1))
 	if err != nil {
 		return err
 	}
 
+	if out.IsBodyTruncated = int64(len(data)) > maxSize; out.IsBodyTruncated {
+		data = data[:maxSize]
+	}
+
+	text := data
+	if out.IsBodyTruncated {
+		// The text could be cut in the middle of the last character.
+		for i := len(text) - 1; i >= 0 && i >= len(text)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(text[i]) {
+				if !utf8.FullRune(text[i:]) {
+					text = text[:i]
+				}
+
+				break
+			}
+		}
+	}
+
+	if utf8.Valid(text) {
+		out.Body, out.BodyEncoding = string(text), BodyEncodingText
+
+		return nil
+	}
+
+	out.Body, out.BodyEncoding = base64.StdEncoding.EncodeToString(data), BodyEncodingBase64
+
 	return nil
 }
+
+// stream writes the data with the metadata into the streamer and closes it.
+func stream(
+	s afd.Streamer,
+	r io.Reader,
+	m afd.Metadata,
+	bufferSize int,
+) (
+	n int64,
+	report interface{},
+	err error,
+) {
+	defer s.Close()
+
+	if hw, ok := s.(afd.HeaderWriter); ok {
+		if err = hw.WriteHeader(m); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if n, err = copyBody(s, r, bufferSize); err != nil {
+		return n, nil, err
+	}
+
+	if f, ok := s.(afd.Finisher); ok {
+		if err = f.Finish(); err != nil {
+			return n, nil, err
+		}
+	}
+
+	if r, ok := s.(afd.Reporter); ok {
+		report = r.Report()
+	}
+
+	return n, report, nil
+}
+
+// splitter creates the splitter which delivers every chunk of split size to its own output. The streamer of the
+// preflight, if it is dialed, is used for the first chunk.
+func (svc *DownloadService) splitter(in *Input, first afd.Streamer, out *Output) (*transform.Splitter, error) {
+	return transform.NewSplitter(in.SplitSize, func(index int) (s afd.Streamer, address string, err error) {
+		if index == 0 && first != nil {
+			return first, out.Output, nil
+		}
+
+		if s, address, err = svc.createStreamer(in.ChunkOutputs(index), in.OutputOptions); err != nil {
+			return nil, "", err
+		}
+
+		if index == 0 {
+			out.Output = address
+		}
+
+		return s, address, nil
+	})
+}
+
+func chunkReports(chunks []transform.Chunk) (reports []ChunkReport) {
+	for _, c := range chunks {
+		reports = append(reports, ChunkReport{
+			Index:        c.Index,
+			Output:       c.Output,
+			Offset:       c.Offset,
+			Bytes:        c.Bytes,
+			SHA256:       c.SHA256,
+			OutputReport: c.Report,
+		})
+	}
+
+	return reports
+}
+
+func metadata(res *http.Response, in *Input) afd.Metadata {
+	m := afd.Metadata{
+		SourceURL:       in.URL,
+		ContentType:     res.Header.Get("Content-Type"),
+		ContentLength:   res.ContentLength,
+		ContentEncoding: res.Header.Get("Content-Encoding"),
+		RequestID:       in.RequestID,
+	}
+
+	if res.Request != nil && res.Request.URL != nil {
+		m.SourceURL = res.Request.URL.String()
+	}
+
+	if res.Request != nil {
+		m.SourceAddress = afd.SourceAddress(res.Request.Context())
+	}
+
+	if in.ZipEntry != "" {
+		m.FileName = in.ZipEntry
+	}
+
+	return m
+}
+
+// decode wraps the body with the decoder of its content encoding and, if the body is a compressed file, of
+// its payload encoding. The metadata is changed to describe the decoded data.
+func decode(
+	res *http.Response,
+	body io.Reader,
+	m *afd.Metadata,
+) (
+	d *transform.Decoder,
+	report *DecodingReport,
+	err error,
+) {
+	var (
+		encodings []string
+		filePath  string
+	)
+
+	if u, parseErr := url.Parse(m.SourceURL); parseErr == nil {
+		filePath = u.Path
+	}
+
+	payload := transform.PayloadEncoding(m.ContentType, filePath)
+	if payload != "" {
+		encodings = append(encodings, payload)
+	}
+
+	encodings = append(encodings, transform.ParseContentEncoding(m.ContentEncoding)...)
+
+	if d, err = transform.NewDecoder(body, encodings); err != nil {
+		return nil, nil, err
+	}
+
+	report = &DecodingReport{
+		ContentEncoding: m.ContentEncoding,
+		Encodings:       d.Applied(),
+	}
+
+	// The transport has already decoded gzip which it asked for by itself.
+	if res.Uncompressed {
+		report.ContentEncoding = transform.EncodingGzip
+		report.Encodings = append([]string{transform.EncodingGzip}, report.Encodings...)
+	}
+
+	if len(d.Applied()) > 0 {
+		m.ContentEncoding, m.ContentLength = "", -1
+	}
+
+	if payload != "" {
+		m.ContentType = ""
+	}
+
+	return d, report, nil
+}
+
+// copyBuffered reads the body in the separate goroutine into the spill buffer, so the source connection is read even
+// if the output is slower than the source.
+func copyBuffered(
+	s afd.Streamer,
+	body io.Reader,
+	c io.Closer,
+	in *Input,
+	out *Output,
+) (
+	err error,
+) {
+	var (
+		sb   = transform.NewSpillBuffer(in.RingBufferSize, in.SpillMaxSize, "")
+		done = make(chan struct{})
+	)
+
+	defer sb.Close()
+
+	go func() {
+		defer close(done)
+
+		_, readErr := io.Copy(sb, body)
+		sb.CloseWrite(readErr)
+	}()
+
+	if _, err = copyBody(s, sb, in.BufferSize); err != nil {
+		// Unblocks the goroutine which could wait for the source or for the buffer.
+		sb.CloseRead(err)
+		_ = c.Close()
+	}
+
+	<-done
+
+	stats := sb.Stats()
+	out.Buffer = &BufferReport{
+		PeakMemoryBytes: stats.PeakMemoryBytes,
+		PeakSpillBytes:  stats.PeakSpillBytes,
+		SpilledBytes:    stats.SpilledBytes,
+	}
+
+	return err
+}
+
+// copyBody copies the body into the streamer. The streamer copies the body by itself if it implements io.ReaderFrom,
+// e.g. tcp.Streamer which copies it through its own buffer of buffer-size, otherwise the buffer of bufferSize is
+// used.
+func copyBody(s afd.Streamer, body io.Reader, bufferSize int) (n int64, err error) {
+	if _, ok := s.(io.ReaderFrom); ok || bufferSize <= 0 {
+		return io.Copy(s, body)
+	}
+
+	return io.CopyBuffer(s, body, make([]byte, bufferSize))
+}
+
+// buckets returns the bucket of the download, if its rate is set, and the global bucket of the service, if it is set.
+func buckets(rate int64, burst int, global *transform.Bucket) (buckets []*transform.Bucket) {
+	if rate > 0 {
+		buckets = append(buckets, transform.NewBucket(rate, burst))
+	}
+
+	if global != nil {
+		buckets = append(buckets, global)
+	}
+
+	return buckets
+}
+
+func newRequestID() (id string, err error) {
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
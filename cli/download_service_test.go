@@ -1,18 +1,55 @@
 package cli
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+
 	afd "github.com/morozovcookie/afifiledownloader"
+	"github.com/morozovcookie/afifiledownloader/s3"
+	"github.com/morozovcookie/afifiledownloader/transform"
 )
 
+type finishingStreamer struct {
+	afd.MockStreamer
+}
+
+func (fs *finishingStreamer) Finish() (err error) {
+	return fs.Called().Error(0)
+}
+
+func (fs *finishingStreamer) Report() (report interface{}) {
+	return fs.Called().Get(0)
+}
+
+type bufferStreamer struct {
+	bytes.Buffer
+}
+
+func (bs *bufferStreamer) Close() (err error) {
+	return nil
+}
+
 func TestDownloadService_Download(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	defaultCallback := func(url string, d time.Duration, c afd.DownloadCallback) (err error) {
 		res := &http.Response{
 			Status:        http.StatusText(http.StatusOK),
@@ -21,34 +58,561 @@ func TestDownloadService_Download(t *testing.T) {
 			ContentLength: int64(len(`{}`)),
 		}
 
-		if err := c(res); err != nil {
-			return err
-		}
+		if err := c(res); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	// forbiddenStorage rejects the credentials of every request.
+	forbiddenStorage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer forbiddenStorage.Close()
+
+	tt := []struct {
+		name   string
+		enable bool
+
+		df afd.DownloadFunc
+
+		sc                StreamerCreator
+		cf                afd.CheckFunc
+		policy            *afd.DestinationPolicy
+		creatorInput      []interface{}
+		creatorOutputFunc func() []interface{}
+
+		in io.Reader
+
+		wantErr bool
+
+		expectedOutput      string
+		expectedPreflight   *PreflightReport
+		expectedReport      interface{}
+		expectedCompression *CompressionReport
+		expectedDecoding    *DecodingReport
+		expectedEncryption  *EncryptionReport
+		expectedBuffer      *BufferReport
+		expectedBody        string
+	}{
+		{
+			name:   "pass",
+			enable: true,
+
+			df: defaultCallback,
+
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
+				s := new(afd.MockStreamer)
+				s.
+					On("Write", []interface{}{[]byte(`{}`)}...).
+					Return([]interface{}{len(`{}`), (error)(nil)}...)
+				s.
+					On("Close").
+					Return([]interface{}{(error)(nil)}...)
+
+				return s, nil
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s","output":"127.0.0.1:5000"}`),
+
+			expectedOutput: "127.0.0.1:5000",
+		},
+		{
+			name:   "fallback output",
+			enable: true,
+
+			df: defaultCallback,
+
+			sc: func(address string, _ OutputOptions) (afd.Streamer, error) {
+				if address == "127.0.0.1:5000" {
+					return nil, errors.New("dial network error")
+				}
+
+				s := new(afd.MockStreamer)
+				s.
+					On("Write", []interface{}{[]byte(`{}`)}...).
+					Return([]interface{}{len(`{}`), (error)(nil)}...)
+				s.
+					On("Close").
+					Return([]interface{}{(error)(nil)}...)
+
+				return s, nil
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","fallback-outputs":["127.0.0.1:5001"]}`),
+
+			expectedOutput: "127.0.0.1:5001",
+		},
+		{
+			name:   "s3 fallback output",
+			enable: true,
+
+			df: defaultCallback,
+
+			sc: func(address string, opts OutputOptions) (afd.Streamer, error) {
+				if strings.HasPrefix(address, s3.Scheme) {
+					s, err := s3.NewStreamer(address, s3.Options{
+						Endpoint:    opts.S3Endpoint,
+						Credentials: s3.Credentials{AccessKey: opts.S3AccessKey, SecretKey: opts.S3SecretKey},
+					})
+					if err != nil {
+						return nil, err
+					}
+
+					return s, nil
+				}
+
+				s := new(afd.MockStreamer)
+				s.
+					On("Write", []interface{}{[]byte(`{}`)}...).
+					Return([]interface{}{len(`{}`), (error)(nil)}...)
+				s.
+					On("Close").
+					Return([]interface{}{(error)(nil)}...)
+
+				return s, nil
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"s3://bucket/index.json","fallback-outputs":["127.0.0.1:5001"],` +
+				`"s3-endpoint":"` + forbiddenStorage.URL + `","s3-access-key":"access","s3-secret-key":"secret"}`),
+
+			expectedOutput: "127.0.0.1:5001",
+		},
+		{
+			name:   "inline body",
+			enable: true,
+
+			df: defaultCallback,
+
+			sc: func(_ string, _ OutputOptions) (s afd.Streamer, err error) {
+				return nil, errors.New("unexpected output")
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s","inline-body":true}`),
+
+			expectedBody: `{}`,
+		},
+		{
+			name:   "all outputs unavailable",
+			enable: true,
+
+			df: defaultCallback,
+
+			sc: func(_ string, _ OutputOptions) (s afd.Streamer, err error) {
+				return nil, errors.New("dial network error")
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","fallback-outputs":["127.0.0.1:5001","127.0.0.1:5002"]}`),
+
+			wantErr: true,
+		},
+		{
+			name:   "preflight",
+			enable: true,
+
+			df: defaultCallback,
+
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
+				s := new(afd.MockStreamer)
+				s.
+					On("Write", []interface{}{[]byte(`{}`)}...).
+					Return([]interface{}{len(`{}`), (error)(nil)}...)
+				s.
+					On("Close").
+					Return([]interface{}{(error)(nil)}...)
+
+				return s, nil
+			},
+			cf: func(_ string, _ time.Duration) (int, int64, string, error) {
+				return http.StatusOK, int64(len(`{}`)), "application/json", nil
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","preflight":true,"preflight-head":true}`),
+
+			expectedOutput: "127.0.0.1:5000",
+			expectedPreflight: &PreflightReport{
+				SourceHost:      "127.0.0.1",
+				SourceAddresses: []string{"127.0.0.1"},
+				Output:          "127.0.0.1:5000",
+				HTTPCode:        http.StatusOK,
+				ContentLength:   int64(len(`{}`)),
+				ContentType:     "application/json",
+			},
+		},
+		{
+			name:   "listen output implies preflight",
+			enable: true,
+
+			df: defaultCallback,
+
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
+				s := new(afd.MockStreamer)
+				s.
+					On("Write", []interface{}{[]byte(`{}`)}...).
+					Return([]interface{}{len(`{}`), (error)(nil)}...)
+				s.
+					On("Close").
+					Return([]interface{}{(error)(nil)}...)
+
+				return s, nil
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"listen://:5000"}`),
+
+			expectedOutput: "listen://:5000",
+			expectedPreflight: &PreflightReport{
+				SourceHost:      "127.0.0.1",
+				SourceAddresses: []string{"127.0.0.1"},
+				Output:          "listen://:5000",
+			},
+		},
+		{
+			name:   "dry run",
+			enable: true,
+
+			df: func(url string, d time.Duration, c afd.DownloadCallback) (err error) {
+				return errors.New("download should not be called")
+			},
+
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
+				s := new(afd.MockStreamer)
+				s.
+					On("Close").
+					Return([]interface{}{(error)(nil)}...)
+
+				return s, nil
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","dry-run":true}`),
+
+			expectedOutput: "127.0.0.1:5000",
+			expectedPreflight: &PreflightReport{
+				SourceHost:      "127.0.0.1",
+				SourceAddresses: []string{"127.0.0.1"},
+				Output:          "127.0.0.1:5000",
+			},
+		},
+		{
+			name:   "dry run listen output",
+			enable: true,
+
+			df: func(url string, d time.Duration, c afd.DownloadCallback) (err error) {
+				return errors.New("download should not be called")
+			},
+
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
+				return nil, errors.New("receiver should not be awaited")
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"listen://:5000","dry-run":true}`),
+
+			expectedOutput: "listen://:5000",
+			expectedPreflight: &PreflightReport{
+				SourceHost:      "127.0.0.1",
+				SourceAddresses: []string{"127.0.0.1"},
+				Output:          "listen://:5000",
+			},
+		},
+		{
+			name:   "preflight blocked destination",
+			enable: true,
+
+			df: func(url string, d time.Duration, c afd.DownloadCallback) (err error) {
+				return errors.New("download should not be called")
+			},
+
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
+				s := new(afd.MockStreamer)
+				s.
+					On("Close").
+					Return([]interface{}{(error)(nil)}...)
+
+				return s, nil
+			},
+
+			policy: &afd.DestinationPolicy{IsBlockPrivate: true},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","preflight":true}`),
+
+			wantErr: true,
+
+			expectedPreflight: &PreflightReport{
+				SourceHost:      "127.0.0.1",
+				SourceAddresses: []string{"127.0.0.1"},
+			},
+		},
+		{
+			name:   "preflight create streamer error",
+			enable: true,
+
+			df: func(url string, d time.Duration, c afd.DownloadCallback) (err error) {
+				return errors.New("download should not be called")
+			},
+
+			sc: func(_ string, _ OutputOptions) (s afd.Streamer, err error) {
+				return nil, errors.New("dial network error")
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","preflight":true}`),
+
+			wantErr: true,
+
+			expectedPreflight: &PreflightReport{
+				SourceHost:      "127.0.0.1",
+				SourceAddresses: []string{"127.0.0.1"},
+			},
+		},
+		{
+			name:   "preflight head error",
+			enable: true,
+
+			df: func(url string, d time.Duration, c afd.DownloadCallback) (err error) {
+				return errors.New("download should not be called")
+			},
+
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
+				s := new(afd.MockStreamer)
+				s.
+					On("Close").
+					Return([]interface{}{(error)(nil)}...)
+
+				return s, nil
+			},
+			cf: func(_ string, _ time.Duration) (int, int64, string, error) {
+				return 0, 0, "", errors.New("head error")
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","preflight":true,"preflight-head":true}`),
+
+			wantErr: true,
+
+			expectedOutput: "127.0.0.1:5000",
+			expectedPreflight: &PreflightReport{
+				SourceHost:      "127.0.0.1",
+				SourceAddresses: []string{"127.0.0.1"},
+				Output:          "127.0.0.1:5000",
+			},
+		},
+		{
+			name:   "finish and report",
+			enable: true,
+
+			df: defaultCallback,
+
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
+				s := new(finishingStreamer)
+				s.
+					On("Write", []interface{}{[]byte(`{}`)}...).
+					Return([]interface{}{len(`{}`), (error)(nil)}...)
+				s.
+					On("Finish").
+					Return([]interface{}{(error)(nil)}...)
+				s.
+					On("Report").
+					Return([]interface{}{"OK"}...)
+				s.
+					On("Close").
+					Return([]interface{}{(error)(nil)}...)
+
+				return s, nil
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","ack":true}`),
+
+			expectedOutput: "127.0.0.1:5000",
+			expectedReport: "OK",
+		},
+		{
+			name:   "finish error",
+			enable: true,
+
+			df: defaultCallback,
+
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
+				s := new(finishingStreamer)
+				s.
+					On("Write", []interface{}{[]byte(`{}`)}...).
+					Return([]interface{}{len(`{}`), (error)(nil)}...)
+				s.
+					On("Finish").
+					Return([]interface{}{errors.New("negative acknowledgement")}...)
+				s.
+					On("Close").
+					Return([]interface{}{(error)(nil)}...)
+
+				return s, nil
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","ack":true}`),
+
+			wantErr: true,
+
+			expectedOutput: "127.0.0.1:5000",
+		},
+		{
+			name:   "compression",
+			enable: true,
+
+			df: defaultCallback,
+
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
+				return &bufferStreamer{}, nil
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","compression":"gzip","compression-level":9}`),
+
+			expectedOutput: "127.0.0.1:5000",
+			expectedCompression: &CompressionReport{
+				Algorithm:       "gzip",
+				RawBytes:        int64(len(`{}`)),
+				CompressedBytes: 23,
+			},
+		},
+		{
+			name:   "encryption",
+			enable: true,
+
+			df: defaultCallback,
+
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
+				return &bufferStreamer{}, nil
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","compression":"gzip",` +
+				`"encryption-recipients":["` + identity.Recipient().String() + `"]}`),
+
+			expectedOutput: "127.0.0.1:5000",
+			expectedCompression: &CompressionReport{
+				Algorithm:       "gzip",
+				RawBytes:        int64(len(`{}`)),
+				CompressedBytes: 27,
+			},
+			expectedEncryption: &EncryptionReport{
+				Recipients:     1,
+				RawBytes:       27,
+				EncryptedBytes: 227,
+			},
+		},
+		{
+			name:   "rate limits",
+			enable: true,
+
+			df: defaultCallback,
+
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
+				s := new(afd.MockStreamer)
+				s.
+					On("Write", []interface{}{[]byte(`{`)}...).
+					Return([]interface{}{1, (error)(nil)}...)
+				s.
+					On("Write", []interface{}{[]byte(`}`)}...).
+					Return([]interface{}{1, (error)(nil)}...)
+				s.
+					On("Close").
+					Return([]interface{}{(error)(nil)}...)
+
+				return s, nil
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","read-rate-limit":1048576,"write-rate-limit":1048576,` +
+				`"write-rate-burst":1}`),
+
+			expectedOutput: "127.0.0.1:5000",
+		},
+		{
+			name:   "ring buffer",
+			enable: true,
+
+			df: defaultCallback,
+
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
+				s := new(afd.MockStreamer)
+				s.
+					On("Write", []interface{}{[]byte(`{}`)}...).
+					Return([]interface{}{len(`{}`), (error)(nil)}...)
+				s.
+					On("Close").
+					Return([]interface{}{(error)(nil)}...)
+
+				return s, nil
+			},
 
-		return nil
-	}
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","ring-buffer-size":1024}`),
 
-	tt := []struct {
-		name   string
-		enable bool
+			expectedOutput: "127.0.0.1:5000",
+			expectedBuffer: &BufferReport{PeakMemoryBytes: 2},
+		},
+		{
+			name:   "ring buffer write error",
+			enable: true,
 
-		df afd.DownloadFunc
+			df: defaultCallback,
 
-		sc                StreamerCreator
-		creatorInput      []interface{}
-		creatorOutputFunc func() []interface{}
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
+				s := new(afd.MockStreamer)
+				s.
+					On("Write", []interface{}{[]byte(`{}`)}...).
+					Return([]interface{}{0, errors.New("write error")}...)
+				s.
+					On("Close").
+					Return([]interface{}{(error)(nil)}...)
 
-		in io.Reader
+				return s, nil
+			},
 
-		wantErr bool
-	}{
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","ring-buffer-size":1024}`),
+
+			wantErr: true,
+
+			expectedOutput: "127.0.0.1:5000",
+			expectedBuffer: &BufferReport{PeakMemoryBytes: 2},
+		},
 		{
-			name:   "pass",
+			name:   "decode",
 			enable: true,
 
-			df: defaultCallback,
+			df: func(url string, d time.Duration, c afd.DownloadCallback) (err error) {
+				buf := new(bytes.Buffer)
+				zw := gzip.NewWriter(buf)
+
+				if _, err = zw.Write([]byte(`{}`)); err != nil {
+					return err
+				}
+
+				if err = zw.Close(); err != nil {
+					return err
+				}
 
-			sc: func(_ string) (afd.Streamer, error) {
+				res := &http.Response{
+					Status:        http.StatusText(http.StatusOK),
+					StatusCode:    http.StatusOK,
+					Header:        http.Header{"Content-Encoding": []string{"gzip"}},
+					Body:          ioutil.NopCloser(buf),
+					ContentLength: int64(buf.Len()),
+				}
+
+				return c(res)
+			},
+
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
 				s := new(afd.MockStreamer)
 				s.
 					On("Write", []interface{}{[]byte(`{}`)}...).
@@ -60,7 +624,39 @@ func TestDownloadService_Download(t *testing.T) {
 				return s, nil
 			},
 
-			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s","output":"127.0.0.1:5000"}`),
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","accept-encoding":"gzip, br","decode":true}`),
+
+			expectedOutput: "127.0.0.1:5000",
+			expectedDecoding: &DecodingReport{
+				ContentEncoding: "gzip",
+				Encodings:       []string{"gzip"},
+			},
+		},
+		{
+			name:   "decode unsupported encoding",
+			enable: true,
+
+			df: func(url string, d time.Duration, c afd.DownloadCallback) (err error) {
+				res := &http.Response{
+					Status:        http.StatusText(http.StatusOK),
+					StatusCode:    http.StatusOK,
+					Header:        http.Header{"Content-Encoding": []string{"compress"}},
+					Body:          ioutil.NopCloser(bytes.NewBufferString(`{}`)),
+					ContentLength: int64(len(`{}`)),
+				}
+
+				return c(res)
+			},
+
+			sc: func(_ string, _ OutputOptions) (s afd.Streamer, err error) {
+				return nil, errors.New("streamer should not be created")
+			},
+
+			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000","accept-encoding":"compress","decode":true}`),
+
+			wantErr: true,
 		},
 		{
 			name:   "empty output",
@@ -68,7 +664,7 @@ func TestDownloadService_Download(t *testing.T) {
 
 			df: defaultCallback,
 
-			sc: func(_ string) (s afd.Streamer, err error) {
+			sc: func(_ string, _ OutputOptions) (s afd.Streamer, err error) {
 				return nil, nil
 			},
 
@@ -82,7 +678,7 @@ func TestDownloadService_Download(t *testing.T) {
 				return nil
 			},
 
-			sc: func(_ string) (s afd.Streamer, err error) {
+			sc: func(_ string, _ OutputOptions) (s afd.Streamer, err error) {
 				return nil, nil
 			},
 
@@ -96,7 +692,7 @@ func TestDownloadService_Download(t *testing.T) {
 
 			df: defaultCallback,
 
-			sc: func(_ string) (s afd.Streamer, err error) {
+			sc: func(_ string, _ OutputOptions) (s afd.Streamer, err error) {
 				return nil, nil
 			},
 
@@ -112,7 +708,7 @@ func TestDownloadService_Download(t *testing.T) {
 				return errors.New("download error")
 			},
 
-			sc: func(_ string) (s afd.Streamer, err error) {
+			sc: func(_ string, _ OutputOptions) (s afd.Streamer, err error) {
 				return nil, nil
 			},
 
@@ -126,7 +722,7 @@ func TestDownloadService_Download(t *testing.T) {
 
 			df: defaultCallback,
 
-			sc: func(_ string) (s afd.Streamer, err error) {
+			sc: func(_ string, _ OutputOptions) (s afd.Streamer, err error) {
 				return nil, errors.New("dial network error")
 			},
 
@@ -140,7 +736,7 @@ func TestDownloadService_Download(t *testing.T) {
 
 			df: defaultCallback,
 
-			sc: func(_ string) (afd.Streamer, error) {
+			sc: func(_ string, _ OutputOptions) (afd.Streamer, error) {
 				s := new(afd.MockStreamer)
 				s.
 					On("Write", []interface{}{[]byte(`{}`)}...).
@@ -155,6 +751,8 @@ func TestDownloadService_Download(t *testing.T) {
 			in: bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s","output":"127.0.0.1:5000"}`),
 
 			wantErr: true,
+
+			expectedOutput: "127.0.0.1:5000",
 		},
 	}
 
@@ -164,15 +762,567 @@ func TestDownloadService_Download(t *testing.T) {
 				t.SkipNow()
 			}
 
-			creator := func(isFollowRedirects bool, maxRedirects int64, isIgnoreSSLCertificates bool) afd.DownloadFunc {
+			creator := func(
+				isFollowRedirects bool,
+				maxRedirects int64,
+				isIgnoreSSLCertificates bool,
+				acceptEncoding string,
+				zipEntry string,
+				schemes []string,
+				policy *afd.DestinationPolicy,
+			) afd.DownloadFunc {
 				return test.df
 			}
-			svc := NewDownloadService(creator, test.sc)
-			err := svc.Download(test.in)
+			checkerCreator := func(isIgnoreSSLCertificates bool, policy *afd.DestinationPolicy) afd.CheckFunc {
+				return test.cf
+			}
+			svc := NewDownloadService(creator, test.sc, checkerCreator)
+			svc.SetDestinationPolicy(test.policy)
+			out := &Output{}
+			err := svc.Download(test.in, out)
 			if (err != nil) != test.wantErr {
 				t.Error(err)
 				t.FailNow()
 			}
+
+			assert.Equal(t, test.expectedOutput, out.Output)
+			assert.Equal(t, test.expectedPreflight, out.Preflight)
+			assert.Equal(t, test.expectedReport, out.OutputReport)
+			assert.Equal(t, test.expectedCompression, out.Compression)
+			assert.Equal(t, test.expectedDecoding, out.Decoding)
+			assert.Equal(t, test.expectedEncryption, out.Encryption)
+			assert.Equal(t, test.expectedBuffer, out.Buffer)
+			assert.Equal(t, test.expectedBody, out.Body)
+		})
+	}
+}
+
+func TestDownloadService_DefaultDestinationPolicy(t *testing.T) {
+	var (
+		actual *afd.DestinationPolicy
+
+		creator = func(
+			isFollowRedirects bool,
+			maxRedirects int64,
+			isIgnoreSSLCertificates bool,
+			acceptEncoding string,
+			zipEntry string,
+			schemes []string,
+			policy *afd.DestinationPolicy,
+		) afd.DownloadFunc {
+			actual = policy
+
+			return func(url string, d time.Duration, c afd.DownloadCallback) (err error) {
+				return errors.New("download should not be called")
+			}
+		}
+		streamerCreator = func(_ string, _ OutputOptions) (afd.Streamer, error) {
+			return &bufferStreamer{}, nil
+		}
+
+		svc = NewDownloadService(creator, streamerCreator, nil)
+	)
+
+	// The loopback source is blocked without any destination options in the input.
+	err := svc.Download(bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",`+
+		`"output":"127.0.0.1:5000","preflight":true}`), &Output{})
+
+	assert.True(t, errors.Is(err, afd.ErrBlockedDestination), err)
+
+	// The downloader gets the same policy, so the source is checked at dial time without preflight too.
+	_ = svc.Download(bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",`+
+		`"output":"127.0.0.1:5000"}`), &Output{})
+
+	assert.True(t, errors.Is(actual.Check(net.ParseIP("127.0.0.1")), afd.ErrBlockedDestination))
+	assert.True(t, errors.Is(actual.Check(net.ParseIP("169.254.169.254")), afd.ErrBlockedDestination))
+}
+
+func TestDownloadService_SetGlobalLimits(t *testing.T) {
+	const downloads = 3
+
+	var (
+		creator = func(
+			isFollowRedirects bool,
+			maxRedirects int64,
+			isIgnoreSSLCertificates bool,
+			acceptEncoding string,
+			zipEntry string,
+			schemes []string,
+			policy *afd.DestinationPolicy,
+		) afd.DownloadFunc {
+			return func(url string, d time.Duration, c afd.DownloadCallback) (err error) {
+				return c(&http.Response{
+					Status:        http.StatusText(http.StatusOK),
+					StatusCode:    http.StatusOK,
+					Body:          ioutil.NopCloser(bytes.NewBufferString(`{}`)),
+					ContentLength: int64(len(`{}`)),
+				})
+			}
+		}
+		streamerCreator = func(_ string, _ OutputOptions) (afd.Streamer, error) {
+			return &bufferStreamer{}, nil
+		}
+
+		svc = NewDownloadService(creator, streamerCreator, nil)
+		wg  sync.WaitGroup
+	)
+
+	// Every download writes 2 bytes, so the shared bucket makes the last one wait for about
+	// (downloads - 1) * 100ms.
+	svc.SetGlobalLimits(nil, transform.NewBucket(20, 2))
+
+	start := time.Now()
+
+	for i := 0; i < downloads; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			in := bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+				`"output":"127.0.0.1:5000"}`)
+			if err := svc.Download(in, &Output{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	minElapsed := (downloads - 1) * 90 * time.Millisecond
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(minElapsed))
+}
+
+func TestDownloadService_SetExecOptions(t *testing.T) {
+	tt := []struct {
+		name   string
+		enable bool
+
+		in string
+
+		expectedErr     error
+		expectedOptions OutputOptions
+	}{
+		{
+			name:   "operator options",
+			enable: true,
+
+			in: `{"url":"http://127.0.0.1:8080/dump.sql","timeout":"1s","output":"exec:psql"}`,
+
+			expectedOptions: OutputOptions{
+				ExecArgs: []string{"-d", "db"},
+				ExecEnv:  map[string]string{"PGHOST": "db"},
+				ExecDir:  "/var/lib/afi",
+			},
+		},
+		{
+			name:   "input args",
+			enable: true,
+
+			in: `{"url":"http://127.0.0.1:8080/dump.sql","timeout":"1s","output":"exec:psql",` +
+				`"exec-args":["-c","\\! id"]}`,
+
+			expectedErr: ErrExecOptionsNotAllowed,
+		},
+		{
+			name:   "input env",
+			enable: true,
+
+			in: `{"url":"http://127.0.0.1:8080/dump.sql","timeout":"1s","output":"exec:psql",` +
+				`"exec-env":{"PATH":"/tmp"}}`,
+
+			expectedErr: ErrExecOptionsNotAllowed,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enable {
+				t.SkipNow()
+			}
+
+			var (
+				creator = func(
+					isFollowRedirects bool,
+					maxRedirects int64,
+					isIgnoreSSLCertificates bool,
+					acceptEncoding string,
+					zipEntry string,
+					schemes []string,
+					policy *afd.DestinationPolicy,
+				) afd.DownloadFunc {
+					return func(url string, d time.Duration, c afd.DownloadCallback) (err error) {
+						return c(&http.Response{
+							Status:     http.StatusText(http.StatusOK),
+							StatusCode: http.StatusOK,
+							Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`)),
+						})
+					}
+				}
+
+				actual OutputOptions
+			)
+
+			svc := NewDownloadService(creator, func(_ string, opts OutputOptions) (afd.Streamer, error) {
+				actual = opts
+
+				return &bufferStreamer{}, nil
+			}, nil)
+			svc.SetExecCommands([]string{"psql"})
+			svc.SetExecOptions([]string{"-d", "db"}, map[string]string{"PGHOST": "db"}, "/var/lib/afi")
+
+			err := svc.Download(bytes.NewBufferString(test.in), &Output{})
+			if test.expectedErr != nil {
+				assert.True(t, errors.Is(err, test.expectedErr), err)
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, test.expectedOptions.ExecArgs, actual.ExecArgs)
+			assert.Equal(t, test.expectedOptions.ExecEnv, actual.ExecEnv)
+			assert.Equal(t, test.expectedOptions.ExecDir, actual.ExecDir)
+		})
+	}
+}
+
+func TestDownloadService_DownloadSplit(t *testing.T) {
+	var (
+		creator = func(
+			isFollowRedirects bool,
+			maxRedirects int64,
+			isIgnoreSSLCertificates bool,
+			acceptEncoding string,
+			zipEntry string,
+			schemes []string,
+			policy *afd.DestinationPolicy,
+		) afd.DownloadFunc {
+			return func(url string, d time.Duration, c afd.DownloadCallback) (err error) {
+				return c(&http.Response{
+					Status:        http.StatusText(http.StatusOK),
+					StatusCode:    http.StatusOK,
+					Body:          ioutil.NopCloser(bytes.NewBufferString(`0123456789`)),
+					ContentLength: int64(len(`0123456789`)),
+				})
+			}
+		}
+
+		streamers       = make(map[string][]*bufferStreamer)
+		streamerCreator = func(address string, _ OutputOptions) (afd.Streamer, error) {
+			if address == "127.0.0.1:5002" {
+				return nil, errors.New("dial network error")
+			}
+
+			s := &bufferStreamer{}
+			streamers[address] = append(streamers[address], s)
+
+			return s, nil
+		}
+
+		svc = NewDownloadService(creator, streamerCreator, nil)
+		out = &Output{}
+	)
+
+	// The third chunk fails over from the unavailable output to the primary one.
+	in := bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+		`"output":"127.0.0.1:5000","split-size":3,"split-outputs":["127.0.0.1:5001","127.0.0.1:5002"]}`)
+	if err := svc.Download(in, out); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedOutputs := []string{"127.0.0.1:5000", "127.0.0.1:5001", "127.0.0.1:5000", "127.0.0.1:5000"}
+	if !assert.Len(t, out.Chunks, len(expectedOutputs)) {
+		t.FailNow()
+	}
+
+	for i, c := range out.Chunks {
+		assert.Equal(t, i, c.Index)
+		assert.Equal(t, expectedOutputs[i], c.Output)
+		assert.Equal(t, int64(i*3), c.Offset)
+	}
+
+	assert.Equal(t, "127.0.0.1:5000", out.Output)
+	assert.Len(t, streamers["127.0.0.1:5000"], 3)
+	assert.Equal(t, `{"index":1,"total":4,"offset":3,"size":3,"request-id":"`+out.RequestID+`"}`+"\n345",
+		streamers["127.0.0.1:5001"][0].String())
+}
+
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (fr *failingReader) Read(p []byte) (n int, err error) {
+	if n, err = fr.r.Read(p); errors.Is(err, io.EOF) {
+		return n, fr.err
+	}
+
+	return n, err
+}
+
+func TestDownloadService_DownloadSplitFailed(t *testing.T) {
+	var (
+		readErr = errors.New("connection reset")
+		creator = func(
+			isFollowRedirects bool,
+			maxRedirects int64,
+			isIgnoreSSLCertificates bool,
+			acceptEncoding string,
+			zipEntry string,
+			schemes []string,
+			policy *afd.DestinationPolicy,
+		) afd.DownloadFunc {
+			return func(url string, d time.Duration, c afd.DownloadCallback) (err error) {
+				return c(&http.Response{
+					Status:     http.StatusText(http.StatusOK),
+					StatusCode: http.StatusOK,
+					Body: ioutil.NopCloser(&failingReader{
+						r:   bytes.NewBufferString(`0123456`),
+						err: readErr,
+					}),
+					ContentLength: int64(len(`0123456789`)),
+				})
+			}
+		}
+		streamerCreator = func(_ string, _ OutputOptions) (afd.Streamer, error) {
+			return &bufferStreamer{}, nil
+		}
+
+		svc = NewDownloadService(creator, streamerCreator, nil)
+		out = &Output{}
+	)
+
+	in := bytes.NewBufferString(`{"url":"http://127.0.0.1:8080/index.html","timeout":"1s",` +
+		`"output":"127.0.0.1:5000","split-size":3}`)
+
+	err := svc.Download(in, out)
+	assert.True(t, errors.Is(err, readErr), err)
+
+	// The manifest lists the chunks which were delivered before the source failed.
+	if !assert.Len(t, out.Chunks, 2) {
+		t.FailNow()
+	}
+
+	for i, c := range out.Chunks {
+		assert.Equal(t, i, c.Index)
+		assert.Equal(t, int64(i*3), c.Offset)
+		assert.Equal(t, int64(3), c.Bytes)
+	}
+}
+
+func TestDownloadService_DownloadExtract(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	for name, body := range map[string]string{"data/a.csv": "a,b", "README.md": "readme"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(body)), Mode: 0o644}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		archive = buf.Bytes()
+		creator = func(
+			isFollowRedirects bool,
+			maxRedirects int64,
+			isIgnoreSSLCertificates bool,
+			acceptEncoding string,
+			zipEntry string,
+			schemes []string,
+			policy *afd.DestinationPolicy,
+		) afd.DownloadFunc {
+			return func(url string, d time.Duration, c afd.DownloadCallback) (err error) {
+				return c(&http.Response{
+					Status:        http.StatusText(http.StatusOK),
+					StatusCode:    http.StatusOK,
+					Header:        http.Header{"Content-Type": []string{"application/x-tar"}},
+					Body:          ioutil.NopCloser(bytes.NewReader(archive)),
+					ContentLength: int64(len(archive)),
+				})
+			}
+		}
+
+		streamers       = make(map[string]*bufferStreamer)
+		streamerCreator = func(address string, _ OutputOptions) (afd.Streamer, error) {
+			s := &bufferStreamer{}
+			streamers[address] = s
+
+			return s, nil
+		}
+	)
+
+	tt := []struct {
+		name    string
+		enabled bool
+
+		in string
+
+		expectedEntries []EntryReport
+		expectedBodies  map[string]string
+	}{
+		{
+			name:    "rules",
+			enabled: true,
+
+			in: `{"url":"http://127.0.0.1:8080/bundle.tar","timeout":"1s","extract":"auto",` +
+				`"extract-rules":[{"pattern":"*.csv","output":"127.0.0.1:5001"}]}`,
+
+			expectedEntries: []EntryReport{
+				{Name: "README.md", Size: 6},
+				{Name: "data/a.csv", Size: 3, Output: "127.0.0.1:5001"},
+			},
+			expectedBodies: map[string]string{"127.0.0.1:5001": "a,b"},
+		},
+		{
+			name:    "default output",
+			enabled: true,
+
+			in: `{"url":"http://127.0.0.1:8080/bundle.tar","timeout":"1s","extract":"tar",` +
+				`"output":"127.0.0.1:5000","extract-rules":[{"pattern":"data/*","output":"127.0.0.1:5001"}]}`,
+
+			expectedEntries: []EntryReport{
+				{Name: "README.md", Size: 6, Output: "127.0.0.1:5000"},
+				{Name: "data/a.csv", Size: 3, Output: "127.0.0.1:5001"},
+			},
+			expectedBodies: map[string]string{"127.0.0.1:5000": "readme", "127.0.0.1:5001": "a,b"},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			for address := range streamers {
+				delete(streamers, address)
+			}
+
+			var (
+				svc = NewDownloadService(creator, streamerCreator, nil)
+				out = &Output{}
+			)
+
+			if err := svc.Download(bytes.NewBufferString(test.in), out); err != nil {
+				t.Fatal(err)
+			}
+
+			// Entries of the map are written in random order.
+			sort.Slice(out.Entries, func(i, j int) bool { return out.Entries[i].Name < out.Entries[j].Name })
+
+			assert.Equal(t, test.expectedEntries, out.Entries)
+
+			bodies := make(map[string]string)
+			for address, s := range streamers {
+				bodies[address] = s.String()
+			}
+
+			assert.Equal(t, test.expectedBodies, bodies)
+		})
+	}
+}
+
+func TestInline(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		body    []byte
+		maxSize int64
+
+		expectedBody      string
+		expectedEncoding  string
+		expectedTruncated bool
+	}{
+		{
+			name:    "text",
+			enabled: true,
+
+			body: []byte(`{"a":"б"}`),
+
+			expectedBody:     `{"a":"б"}`,
+			expectedEncoding: BodyEncodingText,
+		},
+		{
+			name:    "truncated text",
+			enabled: true,
+
+			body:    []byte(`{"a":"б"}`),
+			maxSize: 7,
+
+			expectedBody:      `{"a":"`,
+			expectedEncoding:  BodyEncodingText,
+			expectedTruncated: true,
+		},
+		{
+			name:    "binary",
+			enabled: true,
+
+			body: []byte{0xff, 0x00, 0x01},
+
+			expectedBody:     "/wAB",
+			expectedEncoding: BodyEncodingBase64,
+		},
+		{
+			name:    "truncated binary",
+			enabled: true,
+
+			body:    []byte{0xff, 0x00, 0x01},
+			maxSize: 2,
+
+			expectedBody:      "/wA=",
+			expectedEncoding:  BodyEncodingBase64,
+			expectedTruncated: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			out := &Output{}
+			if err := inline(bytes.NewReader(test.body), test.maxSize, out); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, test.expectedBody, out.Body)
+			assert.Equal(t, test.expectedEncoding, out.BodyEncoding)
+			assert.Equal(t, test.expectedTruncated, out.IsBodyTruncated)
 		})
 	}
 }
+
+func TestCopyBody(t *testing.T) {
+	var (
+		body = struct{ io.Reader }{bytes.NewBufferString(`{}[]`)}
+		s    = new(afd.MockStreamer)
+	)
+
+	s.
+		On("Write", []interface{}{[]byte(`{}`)}...).
+		Return([]interface{}{2, (error)(nil)}...)
+	s.
+		On("Write", []interface{}{[]byte(`[]`)}...).
+		Return([]interface{}{2, (error)(nil)}...)
+
+	n, err := copyBody(s, body, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, int64(4), n)
+	s.AssertNumberOfCalls(t, "Write", 2)
+}
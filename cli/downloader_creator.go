@@ -4,4 +4,15 @@ import (
 	afd "github.com/morozovcookie/afifiledownloader"
 )
 
-type DownloaderCreator func(isFollowRedirects bool, maxRedirects int64, isIgnoreSSLCertificates bool) afd.DownloadFunc
+// DownloaderCreator creates the download function. If zipEntry is not empty, the function downloads only the entry
+// of the remote zip archive. Redirects are followed only to the locations with the scheme from schemes. If policy is
+// not nil, the function does not connect to the addresses blocked by it.
+type DownloaderCreator func(
+	isFollowRedirects bool,
+	maxRedirects int64,
+	isIgnoreSSLCertificates bool,
+	acceptEncoding string,
+	zipEntry string,
+	schemes []string,
+	policy *afd.DestinationPolicy,
+) afd.DownloadFunc
@@ -3,9 +3,23 @@ package cli
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+
+	"github.com/morozovcookie/afifiledownloader/exec"
+	"github.com/morozovcookie/afifiledownloader/tcp"
+	"github.com/morozovcookie/afifiledownloader/transform"
 )
 
 var ErrInvalidDuration = errors.New("invalid duration")
@@ -43,24 +57,177 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 }
 
 const (
-	DefaultMaxRedirects = 5
-	DefaultTimeout      = Duration(time.Second)
+	DefaultMaxRedirects      = 5
+	DefaultTimeout           = Duration(time.Second)
+	DefaultInlineBodyMaxSize = 64 * 1024
 )
 
 type Input struct {
-	IsIgnoreSSLCertificates bool     `json:"ignore-ssl-certificates"`
-	IsFollowRedirects       bool     `json:"follow-redirects"`
-	MaxRedirects            int64    `json:"max-redirects"`
-	URL                     string   `json:"url"`
-	Output                  string   `json:"output"`
-	Timeout                 Duration `json:"timeout"`
+	IsIgnoreSSLCertificates bool          `json:"ignore-ssl-certificates"`
+	IsFollowRedirects       bool          `json:"follow-redirects"`
+	MaxRedirects            int64         `json:"max-redirects"`
+	URL                     string        `json:"url"`
+	URLSchemes              []string      `json:"url-schemes"`
+	Output                  string        `json:"output"`
+	FallbackOutputs         []string      `json:"fallback-outputs"`
+	Timeout                 Duration      `json:"timeout"`
+	IsPreflight             bool          `json:"preflight"`
+	IsPreflightHead         bool          `json:"preflight-head"`
+	IsDryRun                bool          `json:"dry-run"`
+	RequestID               string        `json:"request-id"`
+	Compression             string        `json:"compression"`
+	CompressionLevel        int           `json:"compression-level"`
+	AcceptEncoding          string        `json:"accept-encoding"`
+	IsDecode                bool          `json:"decode"`
+	EncryptionRecipients    []string      `json:"encryption-recipients"`
+	ReadRateLimit           int64         `json:"read-rate-limit"`
+	ReadRateBurst           int           `json:"read-rate-burst"`
+	WriteRateLimit          int64         `json:"write-rate-limit"`
+	WriteRateBurst          int           `json:"write-rate-burst"`
+	RingBufferSize          int           `json:"ring-buffer-size"`
+	SpillMaxSize            int64         `json:"spill-max-size"`
+	SplitSize               int64         `json:"split-size"`
+	SplitOutputs            []string      `json:"split-outputs"`
+	Extract                 string        `json:"extract"`
+	ExtractRules            []ExtractRule `json:"extract-rules"`
+	ZipEntry                string        `json:"zip-entry"`
+	IsInlineBody            bool          `json:"inline-body"`
+	InlineBodyMaxSize       int64         `json:"inline-body-max-size"`
+
+	// ExecCommands are the commands which exec outputs could run. They are set by the service, not by the input, and
+	// exec outputs are rejected if there are none.
+	ExecCommands []string `json:"-"`
+
+	OutputOptions
+}
+
+// ExtractRule routes the entries of the archive which names match the pattern to the output. The pattern without
+// slash is matched against the base name of the entry.
+type ExtractRule struct {
+	Pattern string `json:"pattern"`
+	Output  string `json:"output"`
+}
+
+// Match reports whether the name of the entry matches the pattern of the rule.
+func (r ExtractRule) Match(name string) bool {
+	if !strings.Contains(r.Pattern, "/") {
+		name = path.Base(name)
+	}
+
+	ok, err := path.Match(r.Pattern, name)
+
+	return err == nil && ok
+}
+
+type OutputOptions struct {
+	DialTimeout  Duration `json:"dial-timeout"`
+	WriteTimeout Duration `json:"write-timeout"`
+	IdleTimeout  Duration `json:"idle-timeout"`
+	KeepAlive    Duration `json:"tcp-keepalive"`
+	IsNoDelay    *bool    `json:"tcp-nodelay"`
+	SendBuffer   int      `json:"send-buffer-size"`
+	LocalAddress string   `json:"local-address"`
+	IPFamily     string   `json:"ip-family"`
+	BufferSize   int      `json:"buffer-size"`
+
+	ProxyProtocol      int      `json:"proxy-protocol"`
+	ProxySourceAddress string   `json:"proxy-source-address"`
+	IsFraming          bool     `json:"framing"`
+	FrameSize          int      `json:"frame-size"`
+	IsAck              bool     `json:"ack"`
+	AckTimeout         Duration `json:"ack-timeout"`
+
+	ListenTimeout Duration `json:"listen-timeout"`
+	ListenToken   string   `json:"listen-token"`
+
+	UploadMethod      string            `json:"upload-method"`
+	UploadHeaders     map[string]string `json:"upload-headers"`
+	UploadUsername    string            `json:"upload-username"`
+	UploadPassword    string            `json:"upload-password"`
+	UploadToken       string            `json:"upload-token"`
+	IsUploadChunked   bool              `json:"upload-chunked"`
+	IsUploadMultipart bool              `json:"upload-multipart"`
+	UploadFieldName   string            `json:"upload-field-name"`
+
+	S3Endpoint     string `json:"s3-endpoint"`
+	S3Region       string `json:"s3-region"`
+	S3AccessKey    string `json:"s3-access-key"`
+	S3SecretKey    string `json:"s3-secret-key"`
+	S3SessionToken string `json:"s3-session-token"`
+	S3PartSize     int    `json:"s3-part-size"`
+	S3Concurrency  int    `json:"s3-concurrency"`
+
+	WSFrameSize    int               `json:"ws-frame-size"`
+	WSPingInterval Duration          `json:"ws-ping-interval"`
+	WSHeaders      map[string]string `json:"ws-headers"`
+
+	// ExecArgs, ExecEnv and ExecDir are set by the service from the configuration of the operator. They are decoded
+	// only to reject the input which sets them.
+	ExecArgs []string          `json:"exec-args"`
+	ExecEnv  map[string]string `json:"exec-env"`
+	ExecDir  string            `json:"exec-dir"`
 }
 
 var (
 	ErrInvalidMaxRedirectsValue = errors.New("input validation error: max-redirects value should be between 0 " +
 		"and 9223372036854775806")
-	ErrInvalidURL    = errors.New("input validation error: invalid url address")
-	ErrInvalidOutput = errors.New("input validation error: invalid output address")
+	ErrInvalidURL          = errors.New("input validation error: invalid url address")
+	ErrURLNotAbsolute      = fmt.Errorf("%w: url should be absolute", ErrInvalidURL)
+	ErrURLWithoutHost      = fmt.Errorf("%w: url should have a host", ErrInvalidURL)
+	ErrURLSchemeNotAllowed = fmt.Errorf("%w: url scheme is not allowed", ErrInvalidURL)
+	ErrURLWithUserinfo     = fmt.Errorf("%w: url should not have userinfo, credentials are not supported",
+		ErrInvalidURL)
+	ErrInvalidURLHost    = fmt.Errorf("%w: invalid internationalized host", ErrInvalidURL)
+	ErrInvalidURLSchemes = errors.New("input validation error: url-schemes should be http or https")
+
+	ErrInvalidOutput  = errors.New("input validation error: invalid output address")
+	ErrExecNotAllowed = errors.New("input validation error: exec output command is not allowed")
+
+	ErrExecOptionsNotAllowed = errors.New("input validation error: exec-args, exec-env and exec-dir are set by " +
+		"the operator, not by the input")
+
+	ErrInvalidOutputTimeout = errors.New("input validation error: dial-timeout, write-timeout, idle-timeout, " +
+		"ack-timeout and listen-timeout should not be negative")
+	ErrInvalidSendBufferSize = errors.New("input validation error: send-buffer-size should not be negative")
+	ErrInvalidFrameSize      = errors.New("input validation error: frame-size should not be negative")
+	ErrInvalidBufferSize     = errors.New("input validation error: buffer-size should not be negative")
+	ErrInvalidLocalAddress   = errors.New("input validation error: local-address should be an ip address with " +
+		"an optional port")
+	ErrInvalidIPFamily = errors.New("input validation error: ip-family should be tcp4 or tcp6")
+
+	ErrInvalidProxyProtocol = errors.New("input validation error: proxy-protocol should be 1 or 2")
+	ErrInvalidProxySource   = errors.New("input validation error: proxy-source-address should be an ip address " +
+		"with a port")
+
+	ErrInvalidUploadMethod  = errors.New("input validation error: upload-method should be PUT or POST")
+	ErrInvalidS3PartSize    = errors.New("input validation error: s3-part-size should be between 5MiB and 5GiB")
+	ErrInvalidS3Concurrency = errors.New("input validation error: s3-concurrency should not be negative")
+	ErrInvalidWSFrameSize   = errors.New("input validation error: ws-frame-size should not be negative")
+
+	ErrInvalidCompression    = errors.New("input validation error: invalid compression")
+	ErrInvalidAcceptEncoding = errors.New("input validation error: invalid accept-encoding")
+	ErrInvalidRecipients     = errors.New("input validation error: invalid encryption-recipients")
+	ErrInvalidRateLimit      = errors.New("input validation error: read-rate-limit, read-rate-burst, " +
+		"write-rate-limit and write-rate-burst should not be negative")
+	ErrInvalidRingBuffer = errors.New("input validation error: ring-buffer-size and spill-max-size should not be " +
+		"negative")
+
+	ErrInvalidSplitSize = errors.New("input validation error: split-size should not be negative")
+
+	ErrInvalidExtract      = errors.New("input validation error: extract should be tar, zip or auto")
+	ErrInvalidExtractRule  = errors.New("input validation error: extract rule should have valid pattern and output")
+	ErrExtractIncompatible = errors.New("input validation error: extract could not be used with split-size, " +
+		"compression, encryption-recipients and ring-buffer-size")
+	ErrInvalidInlineBody = errors.New("input validation error: inline-body could not be used with output and " +
+		"extract, inline-body-max-size should not be negative")
+
+	ErrExtractRulesWithoutExtract = errors.New("input validation error: extract-rules could not be used without " +
+		"extract")
+
+	ErrFallbackOutputsWithoutOutput = errors.New("input validation error: fallback-outputs could not be used " +
+		"without output")
+	ErrSplitOutputsWithoutSplit = errors.New("input validation error: split-outputs could not be used without " +
+		"output and split-size")
 )
 
 func (i Input) Validate() (err error) {
@@ -68,7 +235,13 @@ func (i Input) Validate() (err error) {
 		return ErrInvalidMaxRedirectsValue
 	}
 
-	if err = validateURL(i.URL); err != nil {
+	for _, scheme := range i.URLSchemes {
+		if !containsFold(DefaultURLSchemes, scheme) {
+			return ErrInvalidURLSchemes
+		}
+	}
+
+	if err = validateURL(i.URL, i.URLSchemes); err != nil {
 		return err
 	}
 
@@ -76,27 +249,363 @@ func (i Input) Validate() (err error) {
 		return err
 	}
 
+	if i.Output == "" && len(i.FallbackOutputs) > 0 {
+		return ErrFallbackOutputsWithoutOutput
+	}
+
+	if len(i.SplitOutputs) > 0 && (i.Output == "" || i.SplitSize == 0) {
+		return ErrSplitOutputsWithoutSplit
+	}
+
+	for _, output := range i.FallbackOutputs {
+		if output == "" {
+			return ErrInvalidOutput
+		}
+
+		if err = validateOutput(output); err != nil {
+			return err
+		}
+	}
+
+	for _, output := range i.SplitOutputs {
+		if output == "" {
+			return ErrInvalidOutput
+		}
+
+		if err = validateOutput(output); err != nil {
+			return err
+		}
+	}
+
+	if err = i.validateExecOutputs(); err != nil {
+		return err
+	}
+
+	if err = i.OutputOptions.Validate(); err != nil {
+		return err
+	}
+
+	if i.Compression != "" {
+		if err = transform.ValidateCompression(i.Compression, i.CompressionLevel); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCompression, err)
+		}
+	}
+
+	if err = validateAcceptEncoding(i.AcceptEncoding); err != nil {
+		return err
+	}
+
+	if i.ReadRateLimit < 0 || i.ReadRateBurst < 0 || i.WriteRateLimit < 0 || i.WriteRateBurst < 0 {
+		return ErrInvalidRateLimit
+	}
+
+	if i.RingBufferSize < 0 || i.SpillMaxSize < 0 {
+		return ErrInvalidRingBuffer
+	}
+
+	if i.SplitSize < 0 {
+		return ErrInvalidSplitSize
+	}
+
+	if err = i.validateExtract(); err != nil {
+		return err
+	}
+
+	if i.InlineBodyMaxSize < 0 || (i.IsInlineBody && (i.Output != "" || i.Extract != "")) {
+		return ErrInvalidInlineBody
+	}
+
+	if len(i.EncryptionRecipients) > 0 {
+		if _, err = transform.ParseRecipients(i.EncryptionRecipients); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRecipients, err)
+		}
+	}
+
+	return nil
+}
+
+func (i Input) validateExtract() (err error) {
+	if i.Extract == "" {
+		if len(i.ExtractRules) > 0 {
+			return ErrExtractRulesWithoutExtract
+		}
+
+		return nil
+	}
+
+	if i.Extract != ExtractAuto && i.Extract != transform.ArchiveTar && i.Extract != transform.ArchiveZip {
+		return ErrInvalidExtract
+	}
+
+	if i.SplitSize > 0 || i.Compression != "" || len(i.EncryptionRecipients) > 0 || i.RingBufferSize > 0 {
+		return ErrExtractIncompatible
+	}
+
+	for _, rule := range i.ExtractRules {
+		if _, err = path.Match(rule.Pattern, ""); err != nil || rule.Pattern == "" || rule.Output == "" {
+			return ErrInvalidExtractRule
+		}
+
+		if err = validateOutput(rule.Output); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o OutputOptions) Validate() (err error) {
+	if o.DialTimeout < 0 || o.WriteTimeout < 0 || o.IdleTimeout < 0 || o.AckTimeout < 0 || o.ListenTimeout < 0 {
+		return ErrInvalidOutputTimeout
+	}
+
+	if o.SendBuffer < 0 {
+		return ErrInvalidSendBufferSize
+	}
+
+	if o.FrameSize < 0 {
+		return ErrInvalidFrameSize
+	}
+
+	if o.BufferSize < 0 {
+		return ErrInvalidBufferSize
+	}
+
+	if err = validateLocalAddress(o.LocalAddress); err != nil {
+		return err
+	}
+
+	if o.IPFamily != "" && o.IPFamily != "tcp4" && o.IPFamily != "tcp6" {
+		return ErrInvalidIPFamily
+	}
+
+	if o.ProxyProtocol < 0 || o.ProxyProtocol > 2 {
+		return ErrInvalidProxyProtocol
+	}
+
+	if o.ProxySourceAddress != "" {
+		if host, _, err := net.SplitHostPort(o.ProxySourceAddress); err != nil || net.ParseIP(host) == nil {
+			return ErrInvalidProxySource
+		}
+	}
+
+	if o.UploadMethod != "" && o.UploadMethod != http.MethodPut && o.UploadMethod != http.MethodPost {
+		return ErrInvalidUploadMethod
+	}
+
+	if o.S3PartSize != 0 && (o.S3PartSize < MinS3PartSize || o.S3PartSize > MaxS3PartSize) {
+		return ErrInvalidS3PartSize
+	}
+
+	if o.S3Concurrency < 0 {
+		return ErrInvalidS3Concurrency
+	}
+
+	if o.WSFrameSize < 0 {
+		return ErrInvalidWSFrameSize
+	}
+
+	return nil
+}
+
+// validateExecOutputs checks that the commands of all exec outputs are in ExecCommands, so the input could not run
+// an arbitrary command. The arguments, the environment and the working directory of the command could not be set by
+// the input either, the allowed command could run arbitrary code with them, e.g. tar --to-command or LD_PRELOAD.
+func (i Input) validateExecOutputs() (err error) {
+	if len(i.ExecArgs) > 0 || len(i.ExecEnv) > 0 || i.ExecDir != "" {
+		return ErrExecOptionsNotAllowed
+	}
+
+	outputs := append([]string{i.Output}, i.FallbackOutputs...)
+	outputs = append(outputs, i.SplitOutputs...)
+
+	for _, rule := range i.ExtractRules {
+		outputs = append(outputs, rule.Output)
+	}
+
+	for _, output := range outputs {
+		command := strings.TrimPrefix(output, exec.Scheme)
+		if command == output {
+			continue
+		}
+
+		if !contains(i.ExecCommands, command) {
+			return fmt.Errorf("%w: %s", ErrExecNotAllowed, command)
+		}
+	}
+
 	return nil
 }
 
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Outputs returns the primary output followed by the fallback outputs in the order they should be tried.
+func (i Input) Outputs() []string {
+	if i.Output == "" {
+		return nil
+	}
+
+	return append([]string{i.Output}, i.FallbackOutputs...)
+}
+
+// ChunkOutputs returns the outputs for the chunk with the index in the order they should be tried. Chunks are
+// distributed round-robin over the primary output and the split outputs, the other of them and then the fallback
+// outputs are tried if the output of the chunk is unavailable.
+func (i Input) ChunkOutputs(index int) []string {
+	if i.Output == "" {
+		return nil
+	}
+
+	var (
+		rr      = append([]string{i.Output}, i.SplitOutputs...)
+		outputs = make([]string, 0, len(rr)+len(i.FallbackOutputs))
+	)
+
+	for n := range rr {
+		outputs = append(outputs, rr[(index+n)%len(rr)])
+	}
+
+	return append(outputs, i.FallbackOutputs...)
+}
+
+// ExtractAuto means the format of the archive is recognized by its content type or extension.
+const ExtractAuto = "auto"
+
+var (
+	// DefaultURLSchemes are the schemes of the url which the downloader supports.
+	DefaultURLSchemes = []string{"http", "https"}
+
+	UploadSchemes    = []string{"http://", "https://"}
+	WebSocketSchemes = []string{"ws://", "wss://"}
+)
+
+const (
+	S3Scheme = "s3://"
+
+	MinS3PartSize = 5 * 1024 * 1024
+	MaxS3PartSize = 5 * 1024 * 1024 * 1024
+)
+
 const (
-	URLRegex = `(?m)^((([^:/?#]+):)?(//([^/?#]*))?([^?#]*)(\?([^#]*))?(#(.*))?)$`
-
-	HostPortRegex = `(?m)^((((25[0-5])|(2[0-4]\d{1})|([0-1]?\d{1,2}))\.){3}((25[0-5])|(2[0-4]\d{1})|` +
-		`([0-1]?\d{1,2})){1}(:((6553[0-5])|(655[0-2]\d{1})|(65[0-4]\d{2})|(6[0-4]\d{3})|([1-5]\d{4})|` +
-		`([1-9]\d{3})|([1-9]\d{2})|([1-9]\d{1})|([1-9])))?)$`
-	HostnamePortRegex = `(?m)^(((([\d\w]|[\d\w][\d\w\-]*[\d\w])\.)*([\d\w]|[\d\w][\d\w\-]*[\d\w]))` +
-		`(:((6553[0-5])|(655[0-2]\d{1})|(65[0-4]\d{2})|(6[0-4]\d{3})|([1-5]\d{4})|([1-9]\d{3})|` +
-		`([1-9]\d{2})|([1-9]\d{1})|([1-9])))?)$`
+	CodingRegex = `^[\w!#$%&'*+.^|~-]+$`
+
+	HostnameRegex = `^(([\d\w]|[\d\w][\d\w\-]*[\d\w])\.)*([\d\w]|[\d\w][\d\w\-]*[\d\w])$`
 )
 
-func validateURL(s string) (err error) {
+func validateURL(s string, schemes []string) (err error) {
+	_, err = NormalizeURL(s, schemes)
+
+	return err
+}
+
+// NormalizeURL checks that the url is absolute, has a host and the scheme from the list, DefaultURLSchemes if the
+// list is empty. The url with userinfo is rejected, because the credentials would be reported with the url. The
+// internationalized host name is converted into punycode.
+func NormalizeURL(s string, schemes []string) (normalized string, err error) {
+	if s == "" {
+		return "", ErrInvalidURL
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+
+	if !u.IsAbs() {
+		return "", ErrURLNotAbsolute
+	}
+
+	if len(schemes) == 0 {
+		schemes = DefaultURLSchemes
+	}
+
+	if !containsFold(schemes, u.Scheme) {
+		return "", fmt.Errorf("%w: %s", ErrURLSchemeNotAllowed, u.Scheme)
+	}
+
+	if u.User != nil {
+		return "", ErrURLWithUserinfo
+	}
+
+	if u.Hostname() == "" {
+		return "", ErrURLWithoutHost
+	}
+
+	if host := u.Hostname(); net.ParseIP(host) == nil && !isASCII(host) {
+		if host, err = idna.Lookup.ToASCII(host); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidURLHost, err)
+		}
+
+		if u.Port() != "" {
+			host = net.JoinHostPort(host, u.Port())
+		}
+
+		u.Host = host
+	}
+
+	return u.String(), nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateAcceptEncoding checks that the value is a list of codings with optional weights, so it could not be used
+// to inject another header.
+func validateAcceptEncoding(s string) (err error) {
 	if s == "" {
-		return ErrInvalidURL
+		return nil
 	}
 
-	if ok := regexp.MustCompile(URLRegex).MatchString(s); !ok {
-		return ErrInvalidURL
+	if strings.ContainsAny(s, "\r\n") {
+		return ErrInvalidAcceptEncoding
+	}
+
+	for _, coding := range strings.Split(s, ",") {
+		if coding = strings.TrimSpace(strings.SplitN(coding, ";", 2)[0]); coding == "" {
+			return ErrInvalidAcceptEncoding
+		}
+
+		if ok := regexp.MustCompile(CodingRegex).MatchString(coding); !ok {
+			return ErrInvalidAcceptEncoding
+		}
+	}
+
+	return nil
+}
+
+func validateLocalAddress(s string) (err error) {
+	if s == "" || net.ParseIP(s) != nil {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(s)
+	if err != nil || net.ParseIP(host) == nil {
+		return ErrInvalidLocalAddress
 	}
 
 	return nil
@@ -107,13 +616,82 @@ func validateOutput(s string) (err error) {
 		return nil
 	}
 
-	if ok := regexp.MustCompile(HostPortRegex).MatchString(s); ok {
+	for _, scheme := range append(UploadSchemes, WebSocketSchemes...) {
+		if strings.HasPrefix(s, scheme) {
+			return validateUploadOutput(s)
+		}
+	}
+
+	if address := strings.TrimPrefix(s, S3Scheme); address != s {
+		if parts := strings.SplitN(address, "/", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return ErrInvalidOutput
+		}
+
+		return nil
+	}
+
+	if command := strings.TrimPrefix(s, exec.Scheme); command != s {
+		if strings.TrimSpace(command) == "" {
+			return ErrInvalidOutput
+		}
+
+		return nil
+	}
+
+	if address := strings.TrimPrefix(s, tcp.ListenScheme); address != s {
+		return validateHostPort(address, true)
+	}
+
+	return validateHostPort(s, false)
+}
+
+// validateHostPort checks that the address is an ip address, IPv6 one in brackets, or a host name with the port.
+// The host could be omitted and the port could be zero only for the listen address.
+func validateHostPort(s string, isListen bool) (err error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return ErrInvalidOutput
+	}
+
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || (n == 0 && !isListen) {
+		return ErrInvalidOutput
+	}
+
+	if host == "" && isListen {
 		return nil
 	}
 
-	if ok := regexp.MustCompile(HostnamePortRegex).MatchString(s); ok {
+	if net.ParseIP(host) != nil {
 		return nil
 	}
 
-	return ErrInvalidOutput
+	if ok := regexp.MustCompile(HostnameRegex).MatchString(host); !ok {
+		return ErrInvalidOutput
+	}
+
+	// The host name could not end with the numeric label, so the invalid ip address is not taken for it.
+	if labels := strings.Split(host, "."); isNumeric(labels[len(labels)-1]) {
+		return ErrInvalidOutput
+	}
+
+	return nil
+}
+
+func isNumeric(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validateUploadOutput(s string) (err error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return ErrInvalidOutput
+	}
+
+	return nil
 }
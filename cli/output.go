@@ -0,0 +1,75 @@
+package cli
+
+type Output struct {
+	Success       bool     `json:"success"`
+	HTTPCode      int      `json:"http-code,omitempty"`
+	ContentLength int64    `json:"content-length,omitempty"`
+	ContentType   string   `json:"content-type,omitempty"`
+	ErrorMessage  string   `json:"error-message,omitempty"`
+	Redirects     []string `json:"redirects,omitempty"`
+	Output        string   `json:"output,omitempty"`
+	RequestID     string   `json:"request-id,omitempty"`
+
+	OutputReport interface{} `json:"output-report,omitempty"`
+
+	Body            string `json:"body,omitempty"`
+	BodyEncoding    string `json:"body-encoding,omitempty"`
+	IsBodyTruncated bool   `json:"body-truncated,omitempty"`
+
+	Preflight   *PreflightReport   `json:"preflight,omitempty"`
+	Compression *CompressionReport `json:"compression,omitempty"`
+	Decoding    *DecodingReport    `json:"decoding,omitempty"`
+	Encryption  *EncryptionReport  `json:"encryption,omitempty"`
+	Buffer      *BufferReport      `json:"buffer,omitempty"`
+	Chunks      []ChunkReport      `json:"chunks,omitempty"`
+	Entries     []EntryReport      `json:"entries,omitempty"`
+}
+
+type PreflightReport struct {
+	SourceHost      string   `json:"source-host"`
+	SourceAddresses []string `json:"source-addresses,omitempty"`
+	Output          string   `json:"output,omitempty"`
+	HTTPCode        int      `json:"http-code,omitempty"`
+	ContentLength   int64    `json:"content-length,omitempty"`
+	ContentType     string   `json:"content-type,omitempty"`
+}
+
+type CompressionReport struct {
+	Algorithm       string `json:"algorithm"`
+	RawBytes        int64  `json:"raw-bytes"`
+	CompressedBytes int64  `json:"compressed-bytes"`
+	IsSkipped       bool   `json:"skipped"`
+}
+
+type DecodingReport struct {
+	ContentEncoding string   `json:"content-encoding,omitempty"`
+	Encodings       []string `json:"encodings,omitempty"`
+}
+
+type EncryptionReport struct {
+	Recipients     int   `json:"recipients"`
+	RawBytes       int64 `json:"raw-bytes"`
+	EncryptedBytes int64 `json:"encrypted-bytes"`
+}
+
+type BufferReport struct {
+	PeakMemoryBytes int   `json:"peak-memory-bytes"`
+	PeakSpillBytes  int64 `json:"peak-spill-bytes"`
+	SpilledBytes    int64 `json:"spilled-bytes"`
+}
+
+type ChunkReport struct {
+	Index        int         `json:"index"`
+	Output       string      `json:"output"`
+	Offset       int64       `json:"offset"`
+	Bytes        int64       `json:"bytes"`
+	SHA256       string      `json:"sha256"`
+	OutputReport interface{} `json:"output-report,omitempty"`
+}
+
+type EntryReport struct {
+	Name         string      `json:"name"`
+	Size         int64       `json:"size"`
+	Output       string      `json:"output,omitempty"`
+	OutputReport interface{} `json:"output-report,omitempty"`
+}
@@ -4,4 +4,4 @@ import (
 	afd "github.com/morozovcookie/afifiledownloader"
 )
 
-type StreamerCreator func(address string) (s afd.Streamer, err error)
+type StreamerCreator func(address string, opts OutputOptions) (s afd.Streamer, err error)
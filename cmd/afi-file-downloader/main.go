@@ -3,44 +3,115 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	afd "github.com/morozovcookie/afifiledownloader"
 	"github.com/morozovcookie/afifiledownloader/cli"
+	"github.com/morozovcookie/afifiledownloader/exec"
 	"github.com/morozovcookie/afifiledownloader/http"
+	"github.com/morozovcookie/afifiledownloader/s3"
 	"github.com/morozovcookie/afifiledownloader/tcp"
+	"github.com/morozovcookie/afifiledownloader/transform"
+	"github.com/morozovcookie/afifiledownloader/ws"
 )
 
-type Output struct {
-	Success       bool     `json:"success"`
-	HTTPCode      int      `json:"http-code,omitempty"`
-	ContentLength int64    `json:"content-length,omitempty"`
-	ContentType   string   `json:"content-type,omitempty"`
-	ErrorMessage  string   `json:"error-message,omitempty"`
-	Redirects     []string `json:"redirects,omitempty"`
-}
+// ExecCommandsEnv is the list of the commands which exec outputs could run, separated as PATH, e.g.
+// "/usr/bin/psql:/usr/bin/tar". Exec outputs are rejected if it is not set.
+const ExecCommandsEnv = "AFI_EXEC_COMMANDS"
+
+// ExecArgsEnv is JSON array of the arguments, ExecEnvEnv is JSON object of the variables which are added to the
+// environment and ExecDirEnv is the working directory of the commands of exec outputs. The request could not set them.
+const (
+	ExecArgsEnv = "AFI_EXEC_ARGS"
+	ExecEnvEnv  = "AFI_EXEC_ENV"
+	ExecDirEnv  = "AFI_EXEC_DIR"
+)
+
+// AllowedDestinationsEnv and DeniedDestinationsEnv are the lists of ip addresses and CIDRs separated by comma, e.g.
+// "10.1.0.0/16,192.168.1.1". Private and reserved addresses are blocked unless they are allowed, denied addresses are
+// always blocked.
+const (
+	AllowedDestinationsEnv = "AFI_ALLOWED_DESTINATIONS"
+	DeniedDestinationsEnv  = "AFI_DENIED_DESTINATIONS"
+)
+
+// ReadRateLimitEnv and WriteRateLimitEnv are the max rates in bytes per second of reading the source and writing into
+// the outputs, which are shared by all streams of the process, e.g. chunks and archive entries. The limits of the
+// request could not exceed them.
+const (
+	ReadRateLimitEnv  = "AFI_READ_RATE_LIMIT"
+	WriteRateLimitEnv = "AFI_WRITE_RATE_LIMIT"
+)
 
 func main() {
 	var (
-		out = &Output{Success: true}
+		out = &cli.Output{Success: true}
 
 		err error
 	)
 
+	// The output filled before the failure is encoded together with the error, e.g. the manifest of the delivered
+	// chunks.
 	defer func(err *error) {
 		if *err == nil {
 			return
 		}
 
-		if encodeErr := json.NewEncoder(os.Stdout).Encode(&Output{ErrorMessage: (*err).Error()}); encodeErr != nil {
+		out.Success, out.ErrorMessage = false, (*err).Error()
+
+		if encodeErr := json.NewEncoder(os.Stdout).Encode(out); encodeErr != nil {
 			_, _ = fmt.Fprintf(os.Stderr, "encode output error: %v \n", encodeErr)
 		}
 	}(&err)
 
-	svc := cli.NewDownloadService(downloaderCreator(out), tcp.NewStreamer)
+	svc := cli.NewDownloadService(downloaderCreator(out), streamerCreator(), checkerCreator())
+	svc.SetExecCommands(filepath.SplitList(os.Getenv(ExecCommandsEnv)))
+
+	var (
+		execArgs []string
+		execEnv  map[string]string
+	)
+
+	if err = envJSON(ExecArgsEnv, &execArgs); err != nil {
+		return
+	}
+
+	if err = envJSON(ExecEnvEnv, &execEnv); err != nil {
+		return
+	}
+
+	svc.SetExecOptions(execArgs, execEnv, os.Getenv(ExecDirEnv))
+
+	readBucket, err := envBucket(ReadRateLimitEnv)
+	if err != nil {
+		return
+	}
+
+	writeBucket, err := envBucket(WriteRateLimitEnv)
+	if err != nil {
+		return
+	}
+
+	svc.SetGlobalLimits(readBucket, writeBucket)
+
+	policy := &afd.DestinationPolicy{IsBlockPrivate: true}
+
+	if policy.Allow, err = envCIDRs(AllowedDestinationsEnv); err != nil {
+		return
+	}
+
+	if policy.Deny, err = envCIDRs(DeniedDestinationsEnv); err != nil {
+		return
+	}
+
+	svc.SetDestinationPolicy(policy)
 
-	if err = svc.Download(os.Stdin); err != nil {
+	if err = svc.Download(os.Stdin, out); err != nil {
 		return
 	}
 
@@ -49,11 +120,83 @@ func main() {
 	}
 }
 
-func downloaderCreator(out *Output) cli.DownloaderCreator {
-	return func(isFollowRedirects bool, maxRedirects int64, isIgnoreSSLCertificates bool) afd.DownloadFunc {
+// envCIDRs parses the list of ip addresses and CIDRs separated by comma from the environment variable.
+func envCIDRs(name string) (nets []*net.IPNet, err error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	values := strings.Split(value, ",")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+
+	if nets, err = afd.ParseCIDRs(values); err != nil {
+		return nil, fmt.Errorf("%s should be list of ip addresses and CIDRs: %v", name, err)
+	}
+
+	return nets, nil
+}
+
+// envJSON decodes JSON value of the environment variable into v, v is not changed if the variable is not set.
+func envJSON(name string, v interface{}) (err error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	if err = json.Unmarshal([]byte(value), v); err != nil {
+		return fmt.Errorf("%s should be JSON: %v", name, err)
+	}
+
+	return nil
+}
+
+// envBucket creates the bucket with the rate from the environment variable, nil if it is not set.
+func envBucket(name string) (b *transform.Bucket, err error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	rate, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || rate <= 0 {
+		return nil, fmt.Errorf("%s should be positive number of bytes per second: %q", name, value)
+	}
+
+	return transform.NewBucket(rate, 0), nil
+}
+
+func downloaderCreator(out *cli.Output) cli.DownloaderCreator {
+	return func(
+		isFollowRedirects bool,
+		maxRedirects int64,
+		isIgnoreSSLCertificates bool,
+		acceptEncoding string,
+		zipEntry string,
+		schemes []string,
+		policy *afd.DestinationPolicy,
+	) afd.DownloadFunc {
+		if zipEntry != "" {
+			return func(url string, timeout time.Duration, c afd.DownloadCallback) (err error) {
+				downloader := http.NewZipEntryDownloader(zipEntry, isFollowRedirects, maxRedirects,
+					isIgnoreSSLCertificates, schemes, policy)
+				out.HTTPCode, out.ContentLength, out.ContentType, out.Redirects, err = downloader.Download(
+					url, timeout, c)
+
+				if err != nil {
+					return err
+				}
+
+				return nil
+			}
+		}
+
 		if isFollowRedirects {
 			return func(url string, timeout time.Duration, c afd.DownloadCallback) (err error) {
-				downloader := http.NewRedirectDownloader(maxRedirects, isIgnoreSSLCertificates)
+				downloader := http.NewRedirectDownloader(maxRedirects, isIgnoreSSLCertificates, acceptEncoding,
+					schemes, policy)
 				out.HTTPCode, out.ContentLength, out.ContentType, out.Redirects, err = downloader.Download(
 					url, timeout, c)
 
@@ -66,7 +209,7 @@ func downloaderCreator(out *Output) cli.DownloaderCreator {
 		}
 
 		return func(url string, timeout time.Duration, c afd.DownloadCallback) (err error) {
-			downloader := http.NewDownloader(isIgnoreSSLCertificates)
+			downloader := http.NewDownloader(isIgnoreSSLCertificates, acceptEncoding, policy)
 			out.HTTPCode, out.ContentLength, out.ContentType, err = downloader.Download(url, timeout, c)
 
 			if err != nil {
@@ -77,3 +220,105 @@ func downloaderCreator(out *Output) cli.DownloaderCreator {
 		}
 	}
 }
+
+func checkerCreator() cli.CheckerCreator {
+	return func(isIgnoreSSLCertificates bool, policy *afd.DestinationPolicy) afd.CheckFunc {
+		return http.NewChecker(isIgnoreSSLCertificates, policy).Check
+	}
+}
+
+func streamerCreator() cli.StreamerCreator {
+	return func(address string, opts cli.OutputOptions) (afd.Streamer, error) {
+		tcpOpts := tcp.Options{
+			DialTimeout:   time.Duration(opts.DialTimeout),
+			WriteTimeout:  time.Duration(opts.WriteTimeout),
+			IdleTimeout:   time.Duration(opts.IdleTimeout),
+			KeepAlive:     time.Duration(opts.KeepAlive),
+			NoDelay:       opts.IsNoDelay,
+			SendBuffer:    opts.SendBuffer,
+			Network:       opts.IPFamily,
+			LocalAddress:  opts.LocalAddress,
+			Framing:       opts.IsFraming,
+			FrameSize:     opts.FrameSize,
+			Ack:           opts.IsAck,
+			AckTimeout:    time.Duration(opts.AckTimeout),
+			ListenTimeout: time.Duration(opts.ListenTimeout),
+			Token:         opts.ListenToken,
+			BufferSize:    opts.BufferSize,
+			ProxyProtocol: opts.ProxyProtocol,
+			ProxySource:   opts.ProxySourceAddress,
+		}
+
+		if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+			return http.NewUploadStreamer(address, http.UploadOptions{
+				Method:      opts.UploadMethod,
+				Headers:     opts.UploadHeaders,
+				Username:    opts.UploadUsername,
+				Password:    opts.UploadPassword,
+				Token:       opts.UploadToken,
+				Chunked:     opts.IsUploadChunked,
+				Multipart:   opts.IsUploadMultipart,
+				FieldName:   opts.UploadFieldName,
+				DialTimeout: time.Duration(opts.DialTimeout),
+			}), nil
+		}
+
+		if strings.HasPrefix(address, ws.Scheme) || strings.HasPrefix(address, ws.SecureScheme) {
+			return ws.NewStreamer(address, ws.Options{
+				FrameSize:    opts.WSFrameSize,
+				PingInterval: time.Duration(opts.WSPingInterval),
+				DialTimeout:  time.Duration(opts.DialTimeout),
+				WriteTimeout: time.Duration(opts.WriteTimeout),
+				Headers:      opts.WSHeaders,
+			})
+		}
+
+		if strings.HasPrefix(address, exec.Scheme) {
+			return exec.NewStreamer(address, exec.Options{
+				Args: opts.ExecArgs,
+				Env:  opts.ExecEnv,
+				Dir:  opts.ExecDir,
+			})
+		}
+
+		if strings.HasPrefix(address, s3.Scheme) {
+			return s3.NewStreamer(address, s3Options(opts))
+		}
+
+		if strings.HasPrefix(address, tcp.ListenScheme) {
+			return tcp.NewListenStreamer(strings.TrimPrefix(address, tcp.ListenScheme), tcpOpts)
+		}
+
+		return tcp.NewStreamer(address, tcpOpts)
+	}
+}
+
+// s3Options takes credentials and region from the environment if they are not set in the input.
+func s3Options(opts cli.OutputOptions) s3.Options {
+	s3Opts := s3.Options{
+		Endpoint: opts.S3Endpoint,
+		Region:   opts.S3Region,
+		Credentials: s3.Credentials{
+			AccessKey:    opts.S3AccessKey,
+			SecretKey:    opts.S3SecretKey,
+			SessionToken: opts.S3SessionToken,
+		},
+		PartSize:    opts.S3PartSize,
+		Concurrency: opts.S3Concurrency,
+		DialTimeout: time.Duration(opts.DialTimeout),
+	}
+
+	if s3Opts.Region == "" {
+		s3Opts.Region = os.Getenv("AWS_REGION")
+	}
+
+	if s3Opts.Credentials.AccessKey == "" && s3Opts.Credentials.SecretKey == "" {
+		s3Opts.Credentials = s3.Credentials{
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+
+	return s3Opts
+}
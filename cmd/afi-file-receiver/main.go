@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"filippo.io/age"
+
+	"github.com/morozovcookie/afifiledownloader/tcp"
+	"github.com/morozovcookie/afifiledownloader/transform"
+)
+
+var ErrInvalidListenAddress = errors.New("invalid listen address: should be tcp://host:port, tls://host:port " +
+	"or unix:///path")
+
+func main() {
+	var (
+		address     = flag.String("listen", "tcp://0.0.0.0:5000", "tcp://host:port, tls://host:port or unix:///path")
+		dir         = flag.String("dir", ".", "directory for received files")
+		isAck       = flag.Bool("ack", false, "send acknowledgement after every received file")
+		readTimeout = flag.Duration("read-timeout", time.Minute, "max time between two reads, zero disables it")
+		certFile    = flag.String("tls-cert", "", "certificate file for tls listener")
+		keyFile     = flag.String("tls-key", "", "key file for tls listener")
+		identities  = flag.String("identity", "", "age identity file for decryption of received files")
+	)
+
+	flag.Parse()
+
+	if err := run(*address, *dir, *isAck, *readTimeout, *certFile, *keyFile, *identities); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "receive error: %v \n", err)
+
+		os.Exit(1)
+	}
+}
+
+func run(
+	address, dir string,
+	isAck bool,
+	readTimeout time.Duration,
+	certFile, keyFile string,
+	identities string,
+) (
+	err error,
+) {
+	decrypt, err := decryptFunc(identities)
+	if err != nil {
+		return err
+	}
+
+	l, err := listen(address, certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	var (
+		signals = make(chan os.Signal, 1)
+		closed  = make(chan struct{})
+	)
+
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+
+		close(closed)
+		_ = l.Close()
+	}()
+
+	var (
+		mu  sync.Mutex
+		enc = json.NewEncoder(os.Stdout)
+	)
+
+	err = tcp.NewReceiver(dir, isAck, readTimeout, decrypt).Serve(l, func(rec tcp.Record) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if encodeErr := enc.Encode(rec); encodeErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "encode record error: %v \n", encodeErr)
+		}
+	})
+
+	select {
+	case <-closed:
+		return nil
+	default:
+		return err
+	}
+}
+
+func listen(address, certFile, keyFile string) (l net.Listener, err error) {
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		return net.Listen("tcp", strings.TrimPrefix(address, "tcp://"))
+	case strings.HasPrefix(address, "unix://"):
+		return net.Listen("unix", strings.TrimPrefix(address, "unix://"))
+	case strings.HasPrefix(address, "tls://"):
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return tls.Listen("tcp", strings.TrimPrefix(address, "tls://"), &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		})
+	}
+
+	return nil, ErrInvalidListenAddress
+}
+
+// decryptFunc reads age identities from the file. Nil function is returned if the file is not set, so received files
+// are stored as is.
+func decryptFunc(identities string) (decrypt tcp.DecryptFunc, err error) {
+	if identities == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(identities)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	ids, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(r io.Reader) (io.Reader, error) {
+		return transform.NewDecryptor(r, ids)
+	}, nil
+}
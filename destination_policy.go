@@ -0,0 +1,135 @@
+package afifiledownloader
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+var ErrBlockedDestination = errors.New("download error: blocked-destination")
+
+// reservedNets are the IPv4 ranges which are not covered by the net.IP methods, but are not public either.
+var reservedNets = mustParseCIDRs([]string{
+	"0.0.0.0/8",     // "this" network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved and broadcast
+})
+
+// DestinationPolicy decides which ip addresses the source could be requested from. The policy is checked with the
+// resolved address right before the connection is established, so the host name which resolves into the other
+// address on the second lookup does not bypass it.
+//
+// The address from Deny is always blocked, the address from Allow is not blocked even if it is private. Nil policy
+// allows everything.
+type DestinationPolicy struct {
+	// IsBlockPrivate blocks loopback, private, link-local, multicast, unspecified and reserved addresses.
+	IsBlockPrivate bool
+
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// ParseCIDRs parses the list of CIDRs, the ip address without the prefix length is the network of the single
+// address.
+func ParseCIDRs(values []string) (nets []*net.IPNet, err error) {
+	nets = make([]*net.IPNet, 0, len(values))
+
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip address %q", value)
+			}
+
+			if v4 := ip.To4(); v4 != nil {
+				ip = v4
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func mustParseCIDRs(values []string) []*net.IPNet {
+	nets, err := ParseCIDRs(values)
+	if err != nil {
+		panic(err)
+	}
+
+	return nets
+}
+
+// Check returns ErrBlockedDestination if the address is blocked by the policy.
+func (p *DestinationPolicy) Check(ip net.IP) (err error) {
+	if p == nil {
+		return nil
+	}
+
+	// IPv4-mapped IPv6 address is checked as IPv4 one, so ::ffff:127.0.0.1 is the loopback address too.
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+
+	if containsIP(p.Deny, ip) {
+		return fmt.Errorf("%w: %s is denied", ErrBlockedDestination, ip)
+	}
+
+	if containsIP(p.Allow, ip) {
+		return nil
+	}
+
+	if p.IsBlockPrivate && isPrivateIP(ip) {
+		return fmt.Errorf("%w: %s is not public", ErrBlockedDestination, ip)
+	}
+
+	return nil
+}
+
+// Control checks the address the connection is established to, it is used as net.Dialer Control function.
+func (p *DestinationPolicy) Control(_, address string, _ syscall.RawConn) (err error) {
+	if p == nil {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlockedDestination, address)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: %s", ErrBlockedDestination, address)
+	}
+
+	return p.Check(ip)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || containsIP(reservedNets, ip)
+}
@@ -0,0 +1,218 @@
+package afifiledownloader
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDestinationPolicy_Check(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		policy *DestinationPolicy
+		ip     string
+
+		expected error
+	}{
+		{
+			name:    "nil policy",
+			enabled: true,
+
+			ip: "127.0.0.1",
+		},
+		{
+			name:    "public address",
+			enabled: true,
+
+			policy: &DestinationPolicy{IsBlockPrivate: true},
+			ip:     "93.184.216.34",
+		},
+		{
+			name:    "loopback",
+			enabled: true,
+
+			policy: &DestinationPolicy{IsBlockPrivate: true},
+			ip:     "127.0.0.1",
+
+			expected: ErrBlockedDestination,
+		},
+		{
+			name:    "ipv4-mapped loopback",
+			enabled: true,
+
+			policy: &DestinationPolicy{IsBlockPrivate: true},
+			ip:     "::ffff:127.0.0.1",
+
+			expected: ErrBlockedDestination,
+		},
+		{
+			name:    "metadata service",
+			enabled: true,
+
+			policy: &DestinationPolicy{IsBlockPrivate: true},
+			ip:     "169.254.169.254",
+
+			expected: ErrBlockedDestination,
+		},
+		{
+			name:    "private",
+			enabled: true,
+
+			policy: &DestinationPolicy{IsBlockPrivate: true},
+			ip:     "10.1.2.3",
+
+			expected: ErrBlockedDestination,
+		},
+		{
+			name:    "unique local ipv6",
+			enabled: true,
+
+			policy: &DestinationPolicy{IsBlockPrivate: true},
+			ip:     "fd00::1",
+
+			expected: ErrBlockedDestination,
+		},
+		{
+			name:    "carrier-grade nat",
+			enabled: true,
+
+			policy: &DestinationPolicy{IsBlockPrivate: true},
+			ip:     "100.64.0.1",
+
+			expected: ErrBlockedDestination,
+		},
+		{
+			name:    "unspecified",
+			enabled: true,
+
+			policy: &DestinationPolicy{IsBlockPrivate: true},
+			ip:     "0.0.0.0",
+
+			expected: ErrBlockedDestination,
+		},
+		{
+			name:    "private is not blocked",
+			enabled: true,
+
+			policy: &DestinationPolicy{},
+			ip:     "10.1.2.3",
+		},
+		{
+			name:    "allowed private",
+			enabled: true,
+
+			policy: &DestinationPolicy{
+				IsBlockPrivate: true,
+				Allow:          mustParseCIDRs([]string{"10.1.0.0/16"}),
+			},
+			ip: "10.1.2.3",
+		},
+		{
+			name:    "denied public",
+			enabled: true,
+
+			policy: &DestinationPolicy{
+				Deny: mustParseCIDRs([]string{"93.184.216.34"}),
+			},
+			ip: "93.184.216.34",
+
+			expected: ErrBlockedDestination,
+		},
+		{
+			name:    "deny wins over allow",
+			enabled: true,
+
+			policy: &DestinationPolicy{
+				IsBlockPrivate: true,
+				Allow:          mustParseCIDRs([]string{"10.0.0.0/8"}),
+				Deny:           mustParseCIDRs([]string{"10.1.0.0/16"}),
+			},
+			ip: "10.1.2.3",
+
+			expected: ErrBlockedDestination,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			err := test.policy.Check(net.ParseIP(test.ip))
+			if test.expected == nil {
+				assert.NoError(t, err)
+
+				return
+			}
+
+			assert.True(t, errors.Is(err, test.expected), err)
+		})
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		values []string
+
+		wantErr bool
+
+		expected []string
+	}{
+		{
+			name:    "pass",
+			enabled: true,
+
+			values: []string{"10.0.0.0/8", "192.168.1.1", "fd00::/8", "::1"},
+
+			expected: []string{"10.0.0.0/8", "192.168.1.1/32", "fd00::/8", "::1/128"},
+		},
+		{
+			name:    "invalid cidr",
+			enabled: true,
+
+			values: []string{"10.0.0.0/33"},
+
+			wantErr: true,
+		},
+		{
+			name:    "invalid address",
+			enabled: true,
+
+			values: []string{"localhost"},
+
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			nets, err := ParseCIDRs(test.values)
+			if (err != nil) != test.wantErr {
+				t.Fatal(err)
+			}
+
+			if test.wantErr {
+				return
+			}
+
+			actual := make([]string, 0, len(nets))
+			for _, n := range nets {
+				actual = append(actual, n.String())
+			}
+
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
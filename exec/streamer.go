@@ -0,0 +1,198 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+const (
+	Scheme = "exec:"
+
+	DefaultTailSize = 4 * 1024
+)
+
+var (
+	ErrInvalidAddress = errors.New("exec error: address should be exec:command")
+	ErrCommandFailed  = errors.New("exec error: command failed")
+)
+
+type Options struct {
+	// Args are passed to the command after its name. The command is started without shell.
+	Args []string
+
+	// Env is added to the environment of the utility.
+	Env map[string]string
+
+	// Dir is the working directory of the command. Empty means the working directory of the utility.
+	Dir string
+
+	// TailSize is the max number of the last bytes of stdout and stderr which are reported. Zero means
+	// DefaultTailSize.
+	TailSize int
+}
+
+type Report struct {
+	ExitCode int    `json:"exit-code"`
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+}
+
+// Streamer starts the command and streams the data into its stdin. The stdin is closed on Finish, then the command
+// is awaited and its exit code other than zero fails the transfer. Close without Finish kills the command.
+type Streamer struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	stdout *tail
+	stderr *tail
+
+	isDone bool
+	report Report
+}
+
+func NewStreamer(address string, opts Options) (*Streamer, error) {
+	name := strings.TrimPrefix(address, Scheme)
+	if name == address || name == "" {
+		return nil, ErrInvalidAddress
+	}
+
+	if opts.TailSize <= 0 {
+		opts.TailSize = DefaultTailSize
+	}
+
+	s := &Streamer{
+		cmd: exec.Command(name, opts.Args...), // nolint: gosec
+
+		stdout: &tail{size: opts.TailSize},
+		stderr: &tail{size: opts.TailSize},
+	}
+
+	s.cmd.Dir, s.cmd.Stdout, s.cmd.Stderr = opts.Dir, s.stdout, s.stderr
+
+	if len(opts.Env) > 0 {
+		s.cmd.Env = environ(opts.Env)
+	}
+
+	var err error
+	if s.stdin, err = s.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+
+	if err = s.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCommandFailed, err)
+	}
+
+	return s, nil
+}
+
+// environ returns the environment of the utility with env added, the variables are sorted, so the order does not
+// depend on the map.
+func environ(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	environ := os.Environ()
+	for _, key := range keys {
+		environ = append(environ, key+"="+env[key])
+	}
+
+	return environ
+}
+
+// Write writes p into stdin of the command. If the command exited before reading all data, the error says how it
+// exited.
+func (s *Streamer) Write(p []byte) (n int, err error) {
+	if n, err = s.stdin.Write(p); err == nil {
+		return n, nil
+	}
+
+	if waitErr := s.wait(); waitErr != nil {
+		return n, waitErr
+	}
+
+	return n, err
+}
+
+// Finish closes stdin of the command and waits for it.
+func (s *Streamer) Finish() (err error) {
+	return s.wait()
+}
+
+// Report returns the exit code and the tails of stdout and stderr of the command.
+func (s *Streamer) Report() interface{} {
+	return s.report
+}
+
+// Close kills the command if it was not awaited.
+func (s *Streamer) Close() (err error) {
+	if s.isDone {
+		return nil
+	}
+
+	_ = s.cmd.Process.Kill()
+	_ = s.wait()
+
+	return nil
+}
+
+func (s *Streamer) wait() (err error) {
+	if s.isDone {
+		return s.exitErr()
+	}
+
+	_ = s.stdin.Close()
+	err = s.cmd.Wait()
+	s.isDone = true
+
+	s.report = Report{
+		ExitCode: s.cmd.ProcessState.ExitCode(),
+		Stdout:   s.stdout.String(),
+		Stderr:   s.stderr.String(),
+	}
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return fmt.Errorf("%w: %v", ErrCommandFailed, err)
+	}
+
+	return s.exitErr()
+}
+
+func (s *Streamer) exitErr() (err error) {
+	if s.report.ExitCode == 0 {
+		return nil
+	}
+
+	if stderr := strings.TrimSpace(s.report.Stderr); stderr != "" {
+		return fmt.Errorf("%w: exit code %d: %s", ErrCommandFailed, s.report.ExitCode, stderr)
+	}
+
+	return fmt.Errorf("%w: exit code %d", ErrCommandFailed, s.report.ExitCode)
+}
+
+// tail keeps the last size bytes written into it.
+type tail struct {
+	size int
+	buf  []byte
+}
+
+func (t *tail) Write(p []byte) (n int, err error) {
+	if t.buf = append(t.buf, p...); len(t.buf) > t.size {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.size:]...)
+	}
+
+	return len(p), nil
+}
+
+func (t *tail) String() string {
+	return string(t.buf)
+}
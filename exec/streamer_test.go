@@ -0,0 +1,150 @@
+package exec
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamer(t *testing.T) {
+	dir := t.TempDir()
+
+	tt := []struct {
+		name    string
+		enabled bool
+
+		address string
+		opts    Options
+		body    string
+
+		wantErr  bool
+		expected error
+
+		expectedReport Report
+	}{
+		{
+			name:    "pass",
+			enabled: true,
+
+			address: "exec:cat",
+			body:    `{}`,
+
+			expectedReport: Report{Stdout: `{}`},
+		},
+		{
+			name:    "tail",
+			enabled: true,
+
+			address: "exec:cat",
+			opts:    Options{TailSize: 4},
+			body:    `0123456789`,
+
+			expectedReport: Report{Stdout: `6789`},
+		},
+		{
+			name:    "env and dir",
+			enabled: true,
+
+			address: "exec:sh",
+			opts: Options{
+				Args: []string{"-c", `cat >/dev/null; echo "$AFD_TEST $(pwd)"`},
+				Env:  map[string]string{"AFD_TEST": "value"},
+				Dir:  dir,
+			},
+			body: `{}`,
+
+			expectedReport: Report{Stdout: "value " + dir + "\n"},
+		},
+		{
+			name:    "args are not interpreted by shell",
+			enabled: true,
+
+			address: "exec:echo",
+			opts:    Options{Args: []string{"$HOME", ";", "exit 1"}},
+
+			expectedReport: Report{Stdout: "$HOME ; exit 1\n"},
+		},
+		{
+			name:    "exit code",
+			enabled: true,
+
+			address: "exec:sh",
+			opts:    Options{Args: []string{"-c", `cat >/dev/null; echo failed >&2; exit 3`}},
+			body:    `{}`,
+
+			wantErr:  true,
+			expected: ErrCommandFailed,
+
+			expectedReport: Report{ExitCode: 3, Stderr: "failed\n"},
+		},
+		{
+			name:    "exit before reading",
+			enabled: true,
+
+			address: "exec:sh",
+			opts:    Options{Args: []string{"-c", `exit 2`}},
+			body:    strings.Repeat("0", 1024*1024),
+
+			wantErr:  true,
+			expected: ErrCommandFailed,
+
+			expectedReport: Report{ExitCode: 2},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			s, err := NewStreamer(test.address, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer s.Close()
+
+			if _, err = s.Write([]byte(test.body)); err == nil {
+				err = s.Finish()
+			}
+
+			if (err != nil) != test.wantErr {
+				t.Fatal(err)
+			}
+
+			if test.wantErr {
+				assert.True(t, errors.Is(err, test.expected), err)
+			}
+
+			assert.Equal(t, test.expectedReport, s.Report())
+		})
+	}
+}
+
+func TestNewStreamer_Error(t *testing.T) {
+	_, err := NewStreamer("exec:", Options{})
+	assert.True(t, errors.Is(err, ErrInvalidAddress))
+
+	_, err = NewStreamer("exec:afd-command-which-does-not-exist", Options{})
+	assert.True(t, errors.Is(err, ErrCommandFailed))
+}
+
+func TestStreamer_CloseKillsCommand(t *testing.T) {
+	s, err := NewStreamer("exec:sleep", Options{Args: []string{"10"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+	assert.Equal(t, -1, s.Report().(Report).ExitCode)
+}
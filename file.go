@@ -1,8 +1,10 @@
 package afifiledownloader
 
 import (
+	"context"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/stretchr/testify/mock"
@@ -12,10 +14,75 @@ type DownloadCallback func(r *http.Response) (err error)
 
 type DownloadFunc func(url string, d time.Duration, c DownloadCallback) (err error)
 
+type CheckFunc func(url string, d time.Duration) (status int, contentLength int64, contentType string, err error)
+
 type Streamer interface {
 	io.WriteCloser
 }
 
+// Metadata describes the downloaded data.
+type Metadata struct {
+	SourceURL       string `json:"source-url"`
+	ContentType     string `json:"content-type,omitempty"`
+	ContentLength   int64  `json:"content-length"`
+	ContentEncoding string `json:"content-encoding,omitempty"`
+	RequestID       string `json:"request-id,omitempty"`
+	SourceAddress   string `json:"source-address,omitempty"`
+	// FileName is the name of the file if it differs from the name in the source url, e.g. the entry of the archive.
+	FileName string `json:"file-name,omitempty"`
+}
+
+type sourceAddressKey struct{}
+
+type sourceAddress struct {
+	mu      sync.Mutex
+	address string
+}
+
+// WithSourceAddress returns the context which keeps the address of the source server. The requester sets it with
+// SetSourceAddress when the connection to the server is established.
+func WithSourceAddress(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sourceAddressKey{}, &sourceAddress{})
+}
+
+func SetSourceAddress(ctx context.Context, address string) {
+	if sa, ok := ctx.Value(sourceAddressKey{}).(*sourceAddress); ok {
+		sa.mu.Lock()
+		sa.address = address
+		sa.mu.Unlock()
+	}
+}
+
+// SourceAddress returns the address of the source server or empty string if it is unknown.
+func SourceAddress(ctx context.Context) string {
+	sa, ok := ctx.Value(sourceAddressKey{}).(*sourceAddress)
+	if !ok {
+		return ""
+	}
+
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+
+	return sa.address
+}
+
+// HeaderWriter is implemented by streamers which send metadata before the data.
+type HeaderWriter interface {
+	WriteHeader(m Metadata) (err error)
+}
+
+// Finisher is implemented by streamers which need to complete the transfer after all data was written
+// successfully. Close without Finish means the transfer was interrupted.
+type Finisher interface {
+	Finish() (err error)
+}
+
+// Reporter is implemented by streamers which have a result of the transfer to report, e.g. receiver
+// acknowledgement. The report is encoded into the response as is.
+type Reporter interface {
+	Report() (report interface{})
+}
+
 type MockStreamer struct {
 	mock.Mock
 }
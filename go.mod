@@ -1,5 +1,21 @@
 module github.com/morozovcookie/afifiledownloader
 
-go 1.15
+go 1.22
 
-require github.com/stretchr/testify v1.6.1
+require (
+	filippo.io/age v1.2.1
+	github.com/andybalholm/brotli v1.1.1
+	github.com/klauspost/compress v1.18.0
+	github.com/stretchr/testify v1.6.1
+	golang.org/x/net v0.26.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/stretchr/objx v0.1.0 // indirect
+	golang.org/x/crypto v0.24.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+)
@@ -0,0 +1,53 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+var ErrCheckFailed = errors.New("download error: preflight check failed")
+
+type Checker struct {
+	requester *Requester
+}
+
+func NewChecker(isIgnoreSSLCertificates bool, policy *afd.DestinationPolicy) *Checker {
+	return &Checker{
+		requester: NewRequester(isIgnoreSSLCertificates, "", policy),
+	}
+}
+
+// Check sends HEAD request to the url. The status other than 2xx and 3xx is returned together with ErrCheckFailed, so
+// the missing source fails the preflight.
+func (c *Checker) Check(
+	url string,
+	timeout time.Duration,
+) (
+	status int,
+	contentLength int64,
+	contentType string,
+	err error,
+) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(timeout))
+	defer cancel()
+
+	resp, err := c.requester.MakeHeadRequest(ctx, url)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	defer resp.Body.Close()
+
+	status, contentLength, contentType = resp.StatusCode, resp.ContentLength, resp.Header.Get("Content-Type")
+
+	if status < http.StatusOK || status >= http.StatusBadRequest {
+		return status, contentLength, contentType, fmt.Errorf("%w: %s", ErrCheckFailed, resp.Status)
+	}
+
+	return status, contentLength, contentType, nil
+}
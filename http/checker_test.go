@@ -0,0 +1,151 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker_Check(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		srvHandlerPattern string
+		srvHandler        func(w http.ResponseWriter, r *http.Request)
+
+		url     func(string) string
+		timeout time.Duration
+
+		wantErr bool
+
+		expectedStatus        int
+		expectedContentLength int64
+		expectedContentType   string
+	}{
+		{
+			name:    "pass",
+			enabled: true,
+
+			srvHandlerPattern: "/",
+			srvHandler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodHead {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+
+					return
+				}
+
+				w.Header().Add("Content-Type", "application/json")
+				w.Header().Add("Content-Length", "2")
+			},
+
+			url: func(srv string) string {
+				return srv + "/index.html"
+			},
+			timeout: time.Second,
+
+			expectedStatus:        http.StatusOK,
+			expectedContentLength: int64(len([]byte(`{}`))),
+			expectedContentType:   "application/json",
+		},
+		{
+			name:    "not found",
+			enabled: true,
+
+			srvHandlerPattern: "/",
+			srvHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("Content-Type", "text/plain")
+				w.WriteHeader(http.StatusNotFound)
+			},
+
+			url: func(srv string) string {
+				return srv + "/missing.html"
+			},
+			timeout: time.Second,
+
+			wantErr: true,
+
+			expectedStatus:        http.StatusNotFound,
+			expectedContentLength: -1,
+			expectedContentType:   "text/plain",
+		},
+		{
+			name:    "server error",
+			enabled: true,
+
+			srvHandlerPattern: "/",
+			srvHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+
+			url: func(srv string) string {
+				return srv + "/index.html"
+			},
+			timeout: time.Second,
+
+			wantErr: true,
+
+			expectedStatus:        http.StatusServiceUnavailable,
+			expectedContentLength: -1,
+		},
+		{
+			name:    "create request error",
+			enabled: true,
+
+			srvHandlerPattern: "/",
+			srvHandler: func(w http.ResponseWriter, r *http.Request) {
+			},
+
+			url: func(_ string) string {
+				return "ffs^*&^*(U://"
+			},
+			timeout: time.Second,
+
+			wantErr: true,
+		},
+		{
+			name:    "execute request error",
+			enabled: true,
+
+			srvHandlerPattern: "/",
+			srvHandler: func(w http.ResponseWriter, r *http.Request) {
+			},
+
+			url: func(_ string) string {
+				return ""
+			},
+			timeout: time.Second,
+
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc(test.srvHandlerPattern, test.srvHandler)
+
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			checker := NewChecker(false, nil)
+			actualStatus, actualContentLength, actualContentType, err := checker.Check(
+				test.url(srv.URL), test.timeout)
+			if (err != nil) != test.wantErr {
+				t.Error(err)
+				t.FailNow()
+			}
+
+			assert.Equal(t, test.expectedStatus, actualStatus)
+			assert.Equal(t, test.expectedContentLength, actualContentLength)
+			assert.Equal(t, test.expectedContentType, actualContentType)
+		})
+	}
+}
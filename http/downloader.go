@@ -11,9 +11,13 @@ type Downloader struct {
 	requester *Requester
 }
 
-func NewDownloader(isIgnoreSSLCertificates bool) *Downloader {
+func NewDownloader(
+	isIgnoreSSLCertificates bool,
+	acceptEncoding string,
+	policy *afd.DestinationPolicy,
+) *Downloader {
 	return &Downloader{
-		requester: NewRequester(isIgnoreSSLCertificates),
+		requester: NewRequester(isIgnoreSSLCertificates, acceptEncoding, policy),
 	}
 }
 
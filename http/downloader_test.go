@@ -19,9 +19,10 @@ func TestDownloader_Download(t *testing.T) {
 		srvHandlerPattern string
 		srvHandler        func(w http.ResponseWriter, r *http.Request)
 
-		url      func(string) string
-		timeout  time.Duration
-		callback afd.DownloadCallback
+		url            func(string) string
+		timeout        time.Duration
+		acceptEncoding string
+		callback       afd.DownloadCallback
 
 		wantErr bool
 
@@ -123,6 +124,65 @@ func TestDownloader_Download(t *testing.T) {
 
 			wantErr: true,
 		},
+		{
+			name:    "accept encoding",
+			enabled: true,
+
+			srvHandlerPattern: "/",
+			srvHandler: func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "br, zstd", r.Header.Get("Accept-Encoding"))
+
+				w.Header().Add("Content-Type", "text/plain")
+				w.Header().Add("Content-Encoding", "br")
+
+				if _, err := w.Write([]byte(`raw`)); err != nil {
+					t.Error(err)
+				}
+			},
+
+			url: func(srv string) string {
+				return srv + "/index.html"
+			},
+			timeout:        time.Second,
+			acceptEncoding: "br, zstd",
+			callback: func(r *http.Response) (err error) {
+				assert.Equal(t, "br", r.Header.Get("Content-Encoding"))
+				assert.False(t, r.Uncompressed)
+
+				return nil
+			},
+
+			expectedStatus:        http.StatusOK,
+			expectedContentLength: int64(len([]byte(`raw`))),
+			expectedContentType:   "text/plain",
+		},
+		{
+			name:    "source address",
+			enabled: true,
+
+			srvHandlerPattern: "/",
+			srvHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("Content-Type", "application/json")
+
+				if _, err := w.Write([]byte(`{}`)); err != nil {
+					t.Error(err)
+				}
+			},
+
+			url: func(srv string) string {
+				return srv + "/index.html"
+			},
+			timeout: time.Second,
+			callback: func(r *http.Response) (err error) {
+				assert.Equal(t, r.Request.URL.Host, afd.SourceAddress(r.Request.Context()))
+
+				return nil
+			},
+
+			expectedStatus:        http.StatusOK,
+			expectedContentLength: int64(len([]byte(`{}`))),
+			expectedContentType:   "application/json",
+		},
 		{
 			name:    "redirect",
 			enabled: true,
@@ -157,7 +217,7 @@ func TestDownloader_Download(t *testing.T) {
 			srv := httptest.NewServer(mux)
 			defer srv.Close()
 
-			downloader := NewDownloader(false)
+			downloader := NewDownloader(false, test.acceptEncoding, nil)
 			actualStatus, actualContentLength, actualContentType, err := downloader.Download(
 				test.url(srv.URL), test.timeout, test.callback)
 			if (err != nil) != test.wantErr {
@@ -3,7 +3,9 @@ package http
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	afd "github.com/morozovcookie/afifiledownloader"
@@ -12,19 +14,34 @@ import (
 var (
 	ErrToManyRedirects = errors.New("download error: too many redirects")
 	ErrCyclicRequests  = errors.New("download error: cyclic requests")
+
+	ErrRedirectNotAllowed = errors.New("download error: redirect location is not allowed")
 )
 
+// defaultSchemes are the schemes of the redirect location which are allowed if the list is empty.
+var defaultSchemes = []string{"http", "https"}
+
 type RedirectDownloader struct {
 	requester *Requester
 
 	maxRedirects int64
+	schemes      []string
 }
 
-func NewRedirectDownloader(maxRedirects int64, isIgnoreSSLCertificates bool) *RedirectDownloader {
+// NewRedirectDownloader creates the downloader which follows redirects to the locations with the scheme from the
+// list, http and https if the list is empty.
+func NewRedirectDownloader(
+	maxRedirects int64,
+	isIgnoreSSLCertificates bool,
+	acceptEncoding string,
+	schemes []string,
+	policy *afd.DestinationPolicy,
+) *RedirectDownloader {
 	return &RedirectDownloader{
-		requester: NewRequester(isIgnoreSSLCertificates),
+		requester: NewRequester(isIgnoreSSLCertificates, acceptEncoding, policy),
 
 		maxRedirects: maxRedirects,
+		schemes:      schemes,
 	}
 }
 
@@ -69,8 +86,11 @@ func (rd *RedirectDownloader) Download(
 			break
 		}
 
-		u, _ := resp.Location()
-		reqURL = u.String()
+		_ = resp.Body.Close()
+
+		if reqURL, err = redirectLocation(resp, rd.schemes); err != nil {
+			return 0, 0, "", nil, err
+		}
 
 		if _, ok := path[reqURL]; ok {
 			return 0, 0, "", nil, ErrCyclicRequests
@@ -87,6 +107,44 @@ func (rd *RedirectDownloader) Download(
 	return resp.StatusCode, resp.ContentLength, resp.Header.Get("Content-Type"), redirects, nil
 }
 
+// redirectLocation returns the location of the redirect. The location is checked the same way as the requested
+// url: it should have the scheme from the list and should not have userinfo, so the redirect could not lead the
+// downloader to the scheme which was not allowed or report the credentials with the redirects.
+func redirectLocation(resp *http.Response, schemes []string) (location string, err error) {
+	u, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrRedirectNotAllowed, err)
+	}
+
+	if len(schemes) == 0 {
+		schemes = defaultSchemes
+	}
+
+	isAllowed := false
+
+	for _, scheme := range schemes {
+		if strings.EqualFold(scheme, u.Scheme) {
+			isAllowed = true
+
+			break
+		}
+	}
+
+	if !isAllowed {
+		return "", fmt.Errorf("%w: scheme %s", ErrRedirectNotAllowed, u.Scheme)
+	}
+
+	if u.User != nil {
+		return "", fmt.Errorf("%w: userinfo", ErrRedirectNotAllowed)
+	}
+
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("%w: no host", ErrRedirectNotAllowed)
+	}
+
+	return u.String(), nil
+}
+
 func isRedirectChainEnd(status int) bool {
 	return status != http.StatusMovedPermanently && status != http.StatusFound
 }
@@ -2,6 +2,7 @@ package http
 
 import (
 	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
@@ -22,12 +23,15 @@ func TestRedirectDownloader_Download(t *testing.T) {
 		srvHandler        func(w http.ResponseWriter, r *http.Request)
 
 		redirects int64
+		schemes   []string
+		policy    *afd.DestinationPolicy
 
 		url      func(string) string
 		timeout  time.Duration
 		callback afd.DownloadCallback
 
-		wantErr bool
+		wantErr     bool
+		expectedErr error
 
 		expectedStatus        int
 		expectedContentLength int64
@@ -388,6 +392,136 @@ func TestRedirectDownloader_Download(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			name:    "redirect to blocked destination",
+			enabled: true,
+
+			srvHandlerPattern: "/",
+			srvHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("Location", "http://169.254.169.254/latest/meta-data/")
+				w.WriteHeader(http.StatusFound)
+			},
+
+			redirects: 1,
+			policy: &afd.DestinationPolicy{
+				IsBlockPrivate: true,
+				Allow:          []*net.IPNet{{IP: net.IPv4(127, 0, 0, 1), Mask: net.CIDRMask(32, 32)}},
+			},
+
+			url: func(srv string) string {
+				return srv + "/index.html"
+			},
+			timeout: time.Second,
+
+			wantErr:     true,
+			expectedErr: afd.ErrBlockedDestination,
+
+			expectedRedirects: func(srv string) []string {
+				return nil
+			},
+		},
+		{
+			name:    "redirect to not allowed scheme",
+			enabled: true,
+
+			srvHandlerPattern: "/",
+			srvHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("Location", "https://example.com/index.html")
+				w.WriteHeader(http.StatusFound)
+			},
+
+			redirects: 1,
+			schemes:   []string{"http"},
+
+			url: func(srv string) string {
+				return srv + "/index.html"
+			},
+			timeout: time.Second,
+
+			wantErr:     true,
+			expectedErr: ErrRedirectNotAllowed,
+
+			expectedRedirects: func(srv string) []string {
+				return nil
+			},
+		},
+		{
+			name:    "redirect with userinfo",
+			enabled: true,
+
+			srvHandlerPattern: "/",
+			srvHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("Location", "http://user:secret@"+r.Host+"/index.html")
+				w.WriteHeader(http.StatusFound)
+			},
+
+			redirects: 1,
+
+			url: func(srv string) string {
+				return srv + "/index.html"
+			},
+			timeout: time.Second,
+
+			wantErr:     true,
+			expectedErr: ErrRedirectNotAllowed,
+
+			expectedRedirects: func(srv string) []string {
+				return nil
+			},
+		},
+		{
+			name:    "denied destination",
+			enabled: true,
+
+			srvHandlerPattern: "/",
+			srvHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+
+			policy: &afd.DestinationPolicy{
+				Deny: []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+			},
+
+			url: func(srv string) string {
+				return srv + "/index.html"
+			},
+			timeout: time.Second,
+
+			wantErr:     true,
+			expectedErr: afd.ErrBlockedDestination,
+
+			expectedRedirects: func(srv string) []string {
+				return nil
+			},
+		},
+		{
+			name:    "allowed private destination",
+			enabled: true,
+
+			srvHandlerPattern: "/",
+			srvHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+
+			policy: &afd.DestinationPolicy{
+				IsBlockPrivate: true,
+				Allow:          []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+			},
+
+			url: func(srv string) string {
+				return srv + "/index.html"
+			},
+			timeout: time.Second,
+			callback: func(r *http.Response) (err error) {
+				return r.Body.Close()
+			},
+
+			expectedStatus: http.StatusOK,
+
+			expectedRedirects: func(srv string) []string {
+				return []string{}
+			},
+		},
 	}
 
 	for _, test := range tt {
@@ -401,7 +535,7 @@ func TestRedirectDownloader_Download(t *testing.T) {
 			srv := httptest.NewServer(mux)
 			defer srv.Close()
 
-			downloader := NewRedirectDownloader(test.redirects, false)
+			downloader := NewRedirectDownloader(test.redirects, false, "", test.schemes, test.policy)
 			actualStatus, actualContentLength, actualContentType, actualRedirects, err := downloader.Download(
 				test.url(srv.URL), test.timeout, test.callback)
 			if (err != nil) != test.wantErr {
@@ -409,6 +543,10 @@ func TestRedirectDownloader_Download(t *testing.T) {
 				t.FailNow()
 			}
 
+			if test.expectedErr != nil {
+				assert.True(t, errors.Is(err, test.expectedErr), err)
+			}
+
 			assert.Equal(t, test.expectedStatus, actualStatus)
 			assert.Equal(t, test.expectedContentLength, actualContentLength)
 			assert.Equal(t, test.expectedContentType, actualContentType)
@@ -3,41 +3,129 @@ package http
 import (
 	"context"
 	"crypto/tls"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+const (
+	// defaultDialTimeout and defaultKeepAlive are the dialer settings of http.DefaultTransport.
+	defaultDialTimeout = 30 * time.Second
+	defaultKeepAlive   = 30 * time.Second
 )
 
 type Requester struct {
 	c *http.Client
+
+	acceptEncoding string
 }
 
+// NewRequester creates requester. If acceptEncoding is not empty it is sent as Accept-Encoding header and the
+// response body is returned as is, otherwise the transport asks for gzip and decodes it transparently. If policy is
+// not nil, every connection, including the connections of the redirects, is checked with it after the host name is
+// resolved, and the proxy from the environment is not used, because the policy could not check the address behind
+// it.
+//
 // nolint: gosec
-func NewRequester(isIgnoreSSLCertificates bool) (requester *Requester) {
+func NewRequester(
+	isIgnoreSSLCertificates bool,
+	acceptEncoding string,
+	policy *afd.DestinationPolicy,
+) (
+	requester *Requester,
+) {
 	requester = &Requester{
 		c: &http.Client{
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				return http.ErrUseLastResponse
 			},
 		},
+
+		acceptEncoding: acceptEncoding,
 	}
 
-	if !isIgnoreSSLCertificates {
+	if !isIgnoreSSLCertificates && policy == nil {
 		return requester
 	}
 
-	requester.c.Transport = &http.Transport{
-		TLSClientConfig: &tls.Config{
+	// The default transport is cloned, so HTTP/2, the timeouts and the limits of the connection pool are kept.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if isIgnoreSSLCertificates {
+		transport.TLSClientConfig = &tls.Config{
 			InsecureSkipVerify: true,
-		},
+		}
+	}
+
+	if policy != nil {
+		// The proxy is not used explicitly: the connection to the proxy would be checked instead of the source, so
+		// the proxy could reach the blocked address.
+		transport.Proxy = nil
+		transport.DialContext = (&net.Dialer{
+			Timeout:   defaultDialTimeout,
+			KeepAlive: defaultKeepAlive,
+			Control:   policy.Control,
+		}).DialContext
 	}
 
+	requester.c.Transport = transport
+
 	return requester
 }
 
 func (r *Requester) MakeRequest(ctx context.Context, url string) (resp *http.Response, err error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	return r.do(ctx, http.MethodGet, url, "")
+}
+
+func (r *Requester) MakeHeadRequest(ctx context.Context, url string) (resp *http.Response, err error) {
+	return r.do(ctx, http.MethodHead, url, "")
+}
+
+// MakeRangeRequest requests the part of the resource, byteRange is the value of Range header without unit, e.g.
+// "0-99" or "-100". The body is requested without content coding, so the offsets are offsets of the resource.
+func (r *Requester) MakeRangeRequest(
+	ctx context.Context,
+	url string,
+	byteRange string,
+) (
+	resp *http.Response,
+	err error,
+) {
+	return r.do(ctx, http.MethodGet, url, byteRange)
+}
+
+func (r *Requester) do(
+	ctx context.Context,
+	method string,
+	url string,
+	byteRange string,
+) (
+	resp *http.Response,
+	err error,
+) {
+	ctx = afd.WithSourceAddress(ctx)
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			afd.SetSourceAddress(ctx, info.Conn.RemoteAddr().String())
+		},
+	})
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	if r.acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", r.acceptEncoding)
+	}
+
+	if byteRange != "" {
+		req.Header.Set("Range", "bytes="+byteRange)
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+
 	return r.c.Do(req)
 }
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+func TestNewRequester(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		isIgnoreSSLCertificates bool
+		policy                  *afd.DestinationPolicy
+
+		expectedProxy bool
+	}{
+		{
+			name:    "ignore ssl certificates",
+			enabled: true,
+
+			isIgnoreSSLCertificates: true,
+
+			expectedProxy: true,
+		},
+		{
+			name:    "destination policy",
+			enabled: true,
+
+			policy: &afd.DestinationPolicy{IsBlockPrivate: true},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			var (
+				transport = NewRequester(test.isIgnoreSSLCertificates, "", test.policy).c.Transport.(*http.Transport)
+				defaults  = http.DefaultTransport.(*http.Transport)
+			)
+
+			assert.True(t, transport.ForceAttemptHTTP2)
+			assert.Equal(t, defaults.TLSHandshakeTimeout, transport.TLSHandshakeTimeout)
+			assert.Equal(t, defaults.IdleConnTimeout, transport.IdleConnTimeout)
+			assert.Equal(t, defaults.ExpectContinueTimeout, transport.ExpectContinueTimeout)
+			assert.Equal(t, defaults.MaxIdleConns, transport.MaxIdleConns)
+			assert.Equal(t, test.expectedProxy, transport.Proxy != nil)
+			assert.Equal(t, test.isIgnoreSSLCertificates, transport.TLSClientConfig != nil &&
+				transport.TLSClientConfig.InsecureSkipVerify)
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+const (
+	DefaultUploadFieldName = "file"
+	DefaultUploadFileName  = "file"
+
+	MaxUploadReportBodySize = 1024
+)
+
+var (
+	ErrUploadFailed  = errors.New("upload error: unexpected response status")
+	ErrUploadAborted = errors.New("upload error: upload aborted")
+)
+
+type UploadOptions struct {
+	// Method is PUT or POST. Empty means PUT.
+	Method string
+
+	Headers map[string]string
+
+	// Username and Password enable basic authentication.
+	Username string
+	Password string
+
+	// Token enables bearer authentication.
+	Token string
+
+	// Chunked forces chunked transfer encoding even if the content length is known.
+	Chunked bool
+
+	// Multipart sends the data as a file of multipart form with the FieldName field.
+	Multipart bool
+	FieldName string
+
+	DialTimeout time.Duration
+}
+
+type UploadReport struct {
+	Status int    `json:"status"`
+	Body   string `json:"body,omitempty"`
+}
+
+// UploadStreamer streams data as the body of the request to the output url. The request starts with the header,
+// or the first write if there was no header, and the data is passed through the pipe. The connection is not opened
+// by NewUploadStreamer, so the fallback outputs are not tried and the preflight does not check the upload output.
+type UploadStreamer struct {
+	c    *http.Client
+	url  string
+	opts UploadOptions
+
+	once sync.Once
+	pw   *io.PipeWriter
+	w    io.Writer
+	mw   *multipart.Writer
+	done chan struct{}
+
+	report UploadReport
+	err    error
+}
+
+func NewUploadStreamer(url string, opts UploadOptions) *UploadStreamer {
+	if opts.Method == "" {
+		opts.Method = http.MethodPut
+	}
+
+	if opts.FieldName == "" {
+		opts.FieldName = DefaultUploadFieldName
+	}
+
+	return &UploadStreamer{
+		c: &http.Client{
+			Transport: &http.Transport{
+				Proxy:       http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{Timeout: opts.DialTimeout}).DialContext,
+			},
+		},
+		url:  url,
+		opts: opts,
+
+		done: make(chan struct{}),
+	}
+}
+
+func (us *UploadStreamer) WriteHeader(m afd.Metadata) (err error) {
+	us.once.Do(func() {
+		err = us.start(m)
+	})
+
+	return err
+}
+
+func (us *UploadStreamer) Write(p []byte) (n int, err error) {
+	if err = us.WriteHeader(afd.Metadata{ContentLength: -1}); err != nil {
+		return 0, err
+	}
+
+	return us.w.Write(p)
+}
+
+// Finish completes the request body and waits for the response. Response status other than 2xx is an error.
+func (us *UploadStreamer) Finish() (err error) {
+	if err = us.WriteHeader(afd.Metadata{ContentLength: -1}); err != nil {
+		return err
+	}
+
+	if us.mw != nil {
+		if err = us.mw.Close(); err != nil {
+			return err
+		}
+	}
+
+	if err = us.pw.Close(); err != nil {
+		return err
+	}
+
+	<-us.done
+
+	return us.err
+}
+
+func (us *UploadStreamer) Report() (report interface{}) {
+	return us.report
+}
+
+func (us *UploadStreamer) Close() (err error) {
+	if us.pw == nil {
+		return nil
+	}
+
+	_ = us.pw.CloseWithError(ErrUploadAborted)
+
+	<-us.done
+
+	return nil
+}
+
+func (us *UploadStreamer) start(m afd.Metadata) (err error) {
+	pr, pw := io.Pipe()
+
+	// The transport closes the body when it stops sending it, the pipe is closed by do instead, so the writes fail
+	// with the status of the response rather than with io.ErrClosedPipe.
+	req, err := http.NewRequestWithContext(context.Background(), us.opts.Method, us.url, ioutil.NopCloser(pr))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range us.opts.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if us.opts.Username != "" || us.opts.Password != "" {
+		req.SetBasicAuth(us.opts.Username, us.opts.Password)
+	}
+
+	if us.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+us.opts.Token)
+	}
+
+	us.pw, us.w = pw, pw
+
+	if us.opts.Multipart {
+		us.mw = multipart.NewWriter(pw)
+		req.Header.Set("Content-Type", us.mw.FormDataContentType())
+	} else {
+		if m.ContentType != "" && req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", m.ContentType)
+		}
+
+		if m.ContentEncoding != "" && req.Header.Get("Content-Encoding") == "" {
+			req.Header.Set("Content-Encoding", m.ContentEncoding)
+		}
+
+		if !us.opts.Chunked && m.ContentLength > 0 {
+			req.ContentLength = m.ContentLength
+		}
+
+		if !us.opts.Chunked && m.ContentLength == 0 {
+			req.Body = http.NoBody
+		}
+	}
+
+	go us.do(req, pr)
+
+	if us.mw == nil {
+		return nil
+	}
+
+	if us.w, err = us.mw.CreatePart(filePartHeader(us.opts.FieldName, m)); err != nil {
+		_ = pw.CloseWithError(err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (us *UploadStreamer) do(req *http.Request, pr *io.PipeReader) {
+	defer close(us.done)
+
+	resp, err := us.c.Do(req)
+	if err != nil {
+		us.err = err
+		_ = pr.CloseWithError(err)
+
+		return
+	}
+
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, MaxUploadReportBodySize))
+	us.report = UploadReport{Status: resp.StatusCode, Body: string(body)}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		us.err = fmt.Errorf("%w: %d %s", ErrUploadFailed, resp.StatusCode, body)
+		_ = pr.CloseWithError(us.err)
+
+		return
+	}
+
+	// The server could answer before the whole body was sent, so the rest of the writes should fail.
+	_ = pr.CloseWithError(ErrUploadAborted)
+}
+
+func filePartHeader(fieldName string, m afd.Metadata) textproto.MIMEHeader {
+	fileName := DefaultUploadFileName
+
+	if u, err := url.Parse(m.SourceURL); err == nil && m.SourceURL != "" {
+		if base := path.Base(u.Path); base != "/" && base != "." {
+			fileName = base
+		}
+	}
+
+	if m.FileName != "" {
+		fileName = path.Base(m.FileName)
+	}
+
+	contentType := m.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		escapeQuotes(fieldName), escapeQuotes(fileName)))
+	h.Set("Content-Type", contentType)
+
+	return h
+}
+
+func escapeQuotes(s string) string {
+	return strings.NewReplacer("\\", "\\\\", `"`, "\\\"").Replace(s)
+}
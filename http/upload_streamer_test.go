@@ -0,0 +1,226 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+func TestUploadStreamer(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		srvHandler func(t *testing.T, w http.ResponseWriter, r *http.Request)
+
+		opts   UploadOptions
+		header *afd.Metadata
+
+		wantErr bool
+
+		expectedReport UploadReport
+	}{
+		{
+			name:    "content length passthrough",
+			enabled: true,
+
+			srvHandler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+
+				assert.Equal(t, http.MethodPut, r.Method)
+				assert.Equal(t, int64(2), r.ContentLength)
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				assert.Equal(t, "1", r.Header.Get("X-Request-Id"))
+				assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+				assert.Equal(t, []byte(`{}`), body)
+
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte(`created`))
+			},
+
+			opts: UploadOptions{
+				Headers: map[string]string{"X-Request-Id": "1"},
+				Token:   "secret",
+			},
+			header: &afd.Metadata{
+				SourceURL:     "http://127.0.0.1:8080/index.json",
+				ContentType:   "application/json",
+				ContentLength: 2,
+			},
+
+			expectedReport: UploadReport{Status: http.StatusCreated, Body: "created"},
+		},
+		{
+			name:    "chunked",
+			enabled: true,
+
+			srvHandler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, int64(-1), r.ContentLength)
+				assert.Equal(t, []string{"chunked"}, r.TransferEncoding)
+
+				username, password, _ := r.BasicAuth()
+				assert.Equal(t, "user", username)
+				assert.Equal(t, "password", password)
+				assert.Equal(t, []byte(`{}`), body)
+			},
+
+			opts: UploadOptions{
+				Method:   http.MethodPost,
+				Username: "user",
+				Password: "password",
+				Chunked:  true,
+			},
+			header: &afd.Metadata{
+				ContentLength: 2,
+			},
+
+			expectedReport: UploadReport{Status: http.StatusOK},
+		},
+		{
+			name:    "multipart",
+			enabled: true,
+
+			srvHandler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				f, fh, err := r.FormFile("upload")
+				if err != nil {
+					t.Error(err)
+
+					return
+				}
+
+				body, _ := ioutil.ReadAll(f)
+
+				assert.Equal(t, "index.json", fh.Filename)
+				assert.Equal(t, "application/json", fh.Header.Get("Content-Type"))
+				assert.Equal(t, []byte(`{}`), body)
+			},
+
+			opts: UploadOptions{
+				Method:    http.MethodPost,
+				Multipart: true,
+				FieldName: "upload",
+			},
+			header: &afd.Metadata{
+				SourceURL:     "http://127.0.0.1:8080/index.json",
+				ContentType:   "application/json",
+				ContentLength: 2,
+			},
+
+			expectedReport: UploadReport{Status: http.StatusOK},
+		},
+		{
+			name:    "without header",
+			enabled: true,
+
+			srvHandler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+
+				assert.Equal(t, []byte(`{}`), body)
+			},
+
+			expectedReport: UploadReport{Status: http.StatusOK},
+		},
+		{
+			name:    "unexpected status",
+			enabled: true,
+
+			srvHandler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				_, _ = ioutil.ReadAll(r.Body)
+
+				w.WriteHeader(http.StatusForbidden)
+			},
+
+			wantErr: true,
+
+			expectedReport: UploadReport{Status: http.StatusForbidden},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				test.srvHandler(t, w, r)
+			}))
+			defer srv.Close()
+
+			s := NewUploadStreamer(srv.URL+"/upload", test.opts)
+			defer s.Close()
+
+			if test.header != nil {
+				if err := s.WriteHeader(*test.header); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := s.Write([]byte(`{}`)); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.Finish(); (err != nil) != test.wantErr {
+				t.Error(err)
+				t.FailNow()
+			}
+
+			assert.Equal(t, test.expectedReport, s.Report())
+		})
+	}
+}
+
+func TestUploadStreamer_RejectedWrite(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	s := NewUploadStreamer(srv.URL+"/upload", UploadOptions{})
+	defer s.Close()
+
+	var (
+		chunk = bytes.Repeat([]byte(`a`), 64*1024)
+		err   error
+	)
+
+	// The server answers without reading the body, so the writes fail with the status once the response is read.
+	for i := 0; i < 1024 && err == nil; i++ {
+		_, err = s.Write(chunk)
+	}
+
+	assert.True(t, errors.Is(err, ErrUploadFailed), err)
+	assert.True(t, errors.Is(s.Finish(), ErrUploadFailed))
+}
+
+func TestUploadStreamer_Close(t *testing.T) {
+	received := make(chan error, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := ioutil.ReadAll(r.Body)
+		received <- err
+	}))
+	defer srv.Close()
+
+	s := NewUploadStreamer(srv.URL+"/upload", UploadOptions{})
+
+	if _, err := s.Write([]byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Error(t, <-received)
+}
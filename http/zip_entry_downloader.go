@@ -0,0 +1,389 @@
+package http
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+const (
+	// zipTailSize is the max size of the end of central directory record with the comment.
+	zipTailSize = 22 + 0xFFFF
+
+	// zipBlockSize is the min size of the range which is requested when the central directory is read.
+	zipBlockSize = 64 * 1024
+)
+
+var (
+	ErrRangeNotSupported   = errors.New("download error: server does not support range requests")
+	ErrRangeFailed         = errors.New("download error: range request failed")
+	ErrInvalidContentRange = errors.New("download error: invalid content range")
+	ErrInvalidZip          = errors.New("download error: invalid zip archive")
+	ErrZipEntryNotFound    = errors.New("download error: zip entry not found")
+	ErrUnsupportedZipEntry = errors.New("download error: zip entry is encrypted or compressed with unsupported " +
+		"method")
+	ErrZipChecksum = errors.New("download error: zip entry checksum mismatch")
+
+	ErrRedirectNotFollowed = errors.New("download error: zip archive is redirected, but redirects are not followed")
+)
+
+// ZipEntryDownloader downloads the single entry of the remote zip archive without downloading the whole archive.
+// The central directory is read with Range requests from the end of the archive, then only the compressed data of
+// the entry is requested and decompressed on the fly.
+type ZipEntryDownloader struct {
+	requester *Requester
+
+	entry             string
+	isFollowRedirects bool
+	maxRedirects      int64
+	schemes           []string
+}
+
+func NewZipEntryDownloader(
+	entry string,
+	isFollowRedirects bool,
+	maxRedirects int64,
+	isIgnoreSSLCertificates bool,
+	schemes []string,
+	policy *afd.DestinationPolicy,
+) *ZipEntryDownloader {
+	return &ZipEntryDownloader{
+		requester: NewRequester(isIgnoreSSLCertificates, "", policy),
+
+		entry:             entry,
+		isFollowRedirects: isFollowRedirects,
+		maxRedirects:      maxRedirects,
+		schemes:           schemes,
+	}
+}
+
+// Download calls the callback with the response which body is the decompressed content of the entry. Redirects of
+// the first request are followed up to max redirects to the locations with the scheme from the list, the other
+// requests are sent to the final url. If redirects are not followed, the redirect fails the download with
+// ErrRedirectNotFollowed, because its body is not the archive.
+//
+// nolint: bodyclose
+func (zd *ZipEntryDownloader) Download(
+	url string,
+	timeout time.Duration,
+	c afd.DownloadCallback,
+) (
+	status int,
+	contentLength int64,
+	contentType string,
+	redirects []string,
+	err error,
+) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(timeout))
+	defer cancel()
+
+	ra, redirects, err := zd.open(ctx, url)
+	if err != nil {
+		return 0, 0, "", nil, err
+	}
+
+	zr, err := zip.NewReader(ra, ra.size)
+	if err != nil {
+		return 0, 0, "", nil, fmt.Errorf("%w: %v", ErrInvalidZip, err)
+	}
+
+	f := findZipEntry(zr.File, zd.entry)
+	if f == nil {
+		return 0, 0, "", nil, fmt.Errorf("%w: %s", ErrZipEntryNotFound, zd.entry)
+	}
+
+	resp, err := ra.entry(f)
+	if err != nil {
+		return 0, 0, "", nil, err
+	}
+
+	if err = c(resp); err != nil {
+		return 0, 0, "", nil, err
+	}
+
+	return resp.StatusCode, resp.ContentLength, resp.Header.Get("Content-Type"), redirects, nil
+}
+
+// open requests the end of the archive, which has the end of central directory record, and finds out the size of
+// the archive.
+func (zd *ZipEntryDownloader) open(ctx context.Context, url string) (ra *rangeReaderAt, redirects []string, err error) {
+	ra = &rangeReaderAt{ctx: ctx, requester: zd.requester, url: url}
+
+	for left := zd.maxRedirects; ; left-- {
+		resp, err := zd.requester.MakeRangeRequest(ctx, ra.url, "-"+strconv.Itoa(zipTailSize))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if isRedirectChainEnd(resp.StatusCode) {
+			if err = ra.readTail(resp); err != nil {
+				return nil, nil, err
+			}
+
+			return ra, redirects, nil
+		}
+
+		_ = resp.Body.Close()
+
+		if !zd.isFollowRedirects {
+			return nil, nil, fmt.Errorf("%w: %s", ErrRedirectNotFollowed, resp.Status)
+		}
+
+		if left <= 0 {
+			return nil, nil, ErrToManyRedirects
+		}
+
+		if ra.url, err = redirectLocation(resp, zd.schemes); err != nil {
+			return nil, nil, err
+		}
+		redirects = append(redirects, ra.url)
+	}
+}
+
+func findZipEntry(files []*zip.File, name string) *zip.File {
+	name = strings.TrimPrefix(name, "/")
+
+	for _, f := range files {
+		if f.Name == name && f.Mode().IsRegular() {
+			return f
+		}
+	}
+
+	return nil
+}
+
+type segment struct {
+	off  int64
+	data []byte
+}
+
+// rangeReaderAt reads the remote resource with Range requests. Every request gets at least zipBlockSize bytes, the
+// received parts are kept, so the central directory is read with a few requests.
+type rangeReaderAt struct {
+	ctx       context.Context
+	requester *Requester
+	url       string
+
+	size     int64
+	req      *http.Request
+	segments []segment
+}
+
+func (ra *rangeReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off >= ra.size {
+		return 0, io.EOF
+	}
+
+	end := off + int64(len(p))
+	if end > ra.size {
+		end = ra.size
+	}
+
+	for _, seg := range ra.segments {
+		if off >= seg.off && end <= seg.off+int64(len(seg.data)) {
+			return readSegment(p, seg.data[off-seg.off:])
+		}
+	}
+
+	if end-off < zipBlockSize {
+		if end = off + zipBlockSize; end > ra.size {
+			end = ra.size
+		}
+	}
+
+	resp, err := ra.fetch(off, end-1)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	ra.segments = append(ra.segments, segment{off: off, data: data})
+
+	return readSegment(p, data)
+}
+
+func readSegment(p []byte, data []byte) (n int, err error) {
+	if n = copy(p, data); n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (ra *rangeReaderAt) readTail(resp *http.Response) (err error) {
+	defer resp.Body.Close()
+
+	if err = checkRangeResponse(resp); err != nil {
+		return err
+	}
+
+	first, _, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, zipTailSize))
+	if err != nil {
+		return err
+	}
+
+	ra.size, ra.req = size, resp.Request
+	ra.segments = append(ra.segments, segment{off: first, data: data})
+
+	return nil
+}
+
+// fetch requests the bytes from first to last inclusive.
+func (ra *rangeReaderAt) fetch(first, last int64) (resp *http.Response, err error) {
+	resp, err = ra.requester.MakeRangeRequest(ra.ctx, ra.url, strconv.FormatInt(first, 10)+"-"+
+		strconv.FormatInt(last, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkRangeResponse(resp); err != nil {
+		_ = resp.Body.Close()
+
+		return nil, err
+	}
+
+	if start, _, _, err := parseContentRange(resp.Header.Get("Content-Range")); err != nil || start != first {
+		_ = resp.Body.Close()
+
+		return nil, ErrInvalidContentRange
+	}
+
+	ra.req = resp.Request
+
+	return resp, nil
+}
+
+// entry requests the compressed data of the file and returns the response with the decompressed data.
+func (ra *rangeReaderAt) entry(f *zip.File) (resp *http.Response, err error) {
+	if f.Flags&0x1 != 0 || (f.Method != zip.Store && f.Method != zip.Deflate) {
+		return nil, ErrUnsupportedZipEntry
+	}
+
+	offset, err := f.DataOffset()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidZip, err)
+	}
+
+	body := io.ReadCloser(ioutil.NopCloser(bytes.NewReader(nil)))
+
+	if f.CompressedSize64 > 0 {
+		dataResp, err := ra.fetch(offset, offset+int64(f.CompressedSize64)-1)
+		if err != nil {
+			return nil, err
+		}
+
+		body = dataResp.Body
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(f.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return &http.Response{
+		Status:        http.StatusText(http.StatusPartialContent),
+		StatusCode:    http.StatusPartialContent,
+		Header:        http.Header{"Content-Type": []string{contentType}},
+		Body:          newZipEntryBody(body, f),
+		ContentLength: int64(f.UncompressedSize64),
+		Request:       ra.req,
+	}, nil
+}
+
+func checkRangeResponse(resp *http.Response) (err error) {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return nil
+	case http.StatusOK:
+		return ErrRangeNotSupported
+	default:
+		return fmt.Errorf("%w: %s", ErrRangeFailed, resp.Status)
+	}
+}
+
+// parseContentRange parses Content-Range header, e.g. "bytes 0-99/200".
+func parseContentRange(s string) (first, last, size int64, err error) {
+	if _, err = fmt.Sscanf(s, "bytes %d-%d/%d", &first, &last, &size); err != nil {
+		return 0, 0, 0, fmt.Errorf("%w: %q", ErrInvalidContentRange, s)
+	}
+
+	if first < 0 || last < first || size <= last {
+		return 0, 0, 0, fmt.Errorf("%w: %q", ErrInvalidContentRange, s)
+	}
+
+	return first, last, size, nil
+}
+
+// zipEntryBody decompresses the data of the entry and checks its checksum at the end.
+type zipEntryBody struct {
+	body io.ReadCloser
+	r    io.Reader
+
+	h    hash.Hash32
+	crc  uint32
+	n    int64
+	size int64
+}
+
+func newZipEntryBody(body io.ReadCloser, f *zip.File) *zipEntryBody {
+	zb := &zipEntryBody{
+		body: body,
+		r:    body,
+
+		h:    crc32.NewIEEE(),
+		crc:  f.CRC32,
+		size: int64(f.UncompressedSize64),
+	}
+
+	if f.Method == zip.Deflate {
+		zb.r = flate.NewReader(body)
+	}
+
+	return zb
+}
+
+func (zb *zipEntryBody) Read(p []byte) (n int, err error) {
+	n, err = zb.r.Read(p)
+	zb.n += int64(n)
+	_, _ = zb.h.Write(p[:n])
+
+	if errors.Is(err, io.EOF) && (zb.n != zb.size || zb.h.Sum32() != zb.crc) {
+		return n, ErrZipChecksum
+	}
+
+	return n, err
+}
+
+func (zb *zipEntryBody) Close() (err error) {
+	if c, ok := zb.r.(io.Closer); ok && zb.r != io.Reader(zb.body) {
+		_ = c.Close()
+	}
+
+	return zb.body.Close()
+}
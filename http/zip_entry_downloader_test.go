@@ -0,0 +1,275 @@
+package http
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingWriter struct {
+	http.ResponseWriter
+
+	n *int64
+}
+
+func (cw countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.ResponseWriter.Write(p)
+	atomic.AddInt64(cw.n, int64(n))
+
+	return n, err
+}
+
+func TestZipEntryDownloader_Download(t *testing.T) {
+	padding := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(1)).Read(padding)
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	for _, f := range []struct {
+		name   string
+		method uint16
+		body   []byte
+	}{
+		{name: "padding.bin", method: zip.Store, body: padding},
+		{name: "data/index.json", method: zip.Deflate, body: bytes.Repeat([]byte(`{"a":1}`), 100)},
+		{name: "empty.txt", method: zip.Deflate},
+		{name: "stored.txt", method: zip.Store, body: []byte("stored")},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: f.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = w.Write(f.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := buf.Bytes()
+
+	tt := []struct {
+		name    string
+		enabled bool
+
+		handler           func(w http.ResponseWriter, r *http.Request)
+		entry             string
+		isFollowRedirects bool
+		maxRedirects      int64
+
+		wantErr  bool
+		expected error
+
+		expectedBody        []byte
+		expectedContentType string
+		expectedRedirects   int
+	}{
+		{
+			name:    "deflated entry",
+			enabled: true,
+
+			entry: "data/index.json",
+
+			expectedBody:        bytes.Repeat([]byte(`{"a":1}`), 100),
+			expectedContentType: "application/json",
+		},
+		{
+			name:    "stored entry",
+			enabled: true,
+
+			entry: "stored.txt",
+
+			expectedBody:        []byte("stored"),
+			expectedContentType: "text/plain; charset=utf-8",
+		},
+		{
+			name:    "empty entry",
+			enabled: true,
+
+			entry: "empty.txt",
+
+			expectedBody:        []byte{},
+			expectedContentType: "text/plain; charset=utf-8",
+		},
+		{
+			name:    "redirect",
+			enabled: true,
+
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/final.zip" {
+					http.Redirect(w, r, "/final.zip", http.StatusFound)
+
+					return
+				}
+
+				http.ServeContent(w, r, "final.zip", time.Time{}, bytes.NewReader(archive))
+			},
+			entry:             "stored.txt",
+			isFollowRedirects: true,
+			maxRedirects:      1,
+
+			expectedBody:        []byte("stored"),
+			expectedContentType: "text/plain; charset=utf-8",
+			expectedRedirects:   1,
+		},
+		{
+			name:    "redirect with userinfo",
+			enabled: true,
+
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "http://user:secret@"+r.Host+"/final.zip", http.StatusFound)
+			},
+			entry:             "stored.txt",
+			isFollowRedirects: true,
+			maxRedirects:      1,
+
+			wantErr:  true,
+			expected: ErrRedirectNotAllowed,
+		},
+		{
+			name:    "redirect not followed",
+			enabled: true,
+
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/final.zip", http.StatusFound)
+			},
+			entry: "stored.txt",
+
+			wantErr:  true,
+			expected: ErrRedirectNotFollowed,
+		},
+		{
+			name:    "too many redirects",
+			enabled: true,
+
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/final.zip", http.StatusFound)
+			},
+			entry:             "stored.txt",
+			isFollowRedirects: true,
+
+			wantErr:  true,
+			expected: ErrToManyRedirects,
+		},
+		{
+			name:    "entry not found",
+			enabled: true,
+
+			entry: "missing.txt",
+
+			wantErr:  true,
+			expected: ErrZipEntryNotFound,
+		},
+		{
+			name:    "ranges not supported",
+			enabled: true,
+
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write(archive)
+			},
+			entry: "stored.txt",
+
+			wantErr:  true,
+			expected: ErrRangeNotSupported,
+		},
+		{
+			name:    "not a zip",
+			enabled: true,
+
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "bundle.zip", time.Time{}, bytes.NewReader([]byte("not a zip archive")))
+			},
+			entry: "stored.txt",
+
+			wantErr:  true,
+			expected: ErrInvalidZip,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			var (
+				served  int64
+				handler = test.handler
+			)
+
+			if handler == nil {
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					http.ServeContent(w, r, "bundle.zip", time.Time{}, bytes.NewReader(archive))
+				}
+			}
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handler(countingWriter{ResponseWriter: w, n: &served}, r)
+			}))
+			defer srv.Close()
+
+			var body []byte
+
+			status, contentLength, contentType, redirects, err := NewZipEntryDownloader(test.entry,
+				test.isFollowRedirects, test.maxRedirects, false, nil, nil).Download(srv.URL+"/bundle.zip", time.Second,
+				func(r *http.Response) (err error) {
+					defer r.Body.Close()
+
+					body, err = ioutil.ReadAll(r.Body)
+
+					return err
+				})
+			if (err != nil) != test.wantErr {
+				t.Fatal(err)
+			}
+
+			if test.wantErr {
+				assert.True(t, errors.Is(err, test.expected), err)
+
+				return
+			}
+
+			assert.Equal(t, http.StatusPartialContent, status)
+			assert.Equal(t, int64(len(test.expectedBody)), contentLength)
+			assert.Equal(t, test.expectedContentType, contentType)
+			assert.Equal(t, test.expectedBody, body)
+			assert.Len(t, redirects, test.expectedRedirects)
+			assert.Less(t, served, int64(len(padding)))
+		})
+	}
+}
+
+func TestZipEntryBody_Checksum(t *testing.T) {
+	zb := newZipEntryBody(ioutil.NopCloser(bytes.NewReader([]byte("data"))), &zip.File{
+		FileHeader: zip.FileHeader{Method: zip.Store, CRC32: 1, UncompressedSize64: 4},
+	})
+
+	_, err := ioutil.ReadAll(zb)
+
+	assert.True(t, errors.Is(err, ErrZipChecksum))
+}
+
+func TestParseContentRange(t *testing.T) {
+	first, last, size, err := parseContentRange("bytes 10-19/20")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []int64{10, 19, 20}, []int64{first, last, size})
+
+	_, _, _, err = parseContentRange("bytes 0-19/*")
+	assert.True(t, errors.Is(err, ErrInvalidContentRange))
+}
@@ -0,0 +1,421 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+const (
+	Scheme = "s3://"
+
+	DefaultRegion      = "us-east-1"
+	DefaultPartSize    = 8 * 1024 * 1024
+	MinPartSize        = 5 * 1024 * 1024
+	MaxPartSize        = 5 * 1024 * 1024 * 1024
+	DefaultConcurrency = 4
+
+	maxErrorBodySize = 1024
+)
+
+var (
+	ErrInvalidAddress = errors.New("s3 error: address should be s3://bucket/key")
+	ErrRequestFailed  = errors.New("s3 error: request failed")
+)
+
+type Options struct {
+	// Endpoint is the scheme and host of the storage, e.g. http://127.0.0.1:9000. Empty means AWS endpoint of the
+	// region. Requests use path-style addressing.
+	Endpoint string
+
+	// Region is used for signing. Empty means DefaultRegion.
+	Region string
+
+	Credentials Credentials
+
+	// PartSize is the size of the every part except the last one. Zero means DefaultPartSize.
+	PartSize int
+
+	// Concurrency limits the number of parts which are uploaded at the same time. Zero means DefaultConcurrency.
+	Concurrency int
+
+	DialTimeout time.Duration
+}
+
+type Report struct {
+	ETag      string `json:"etag"`
+	VersionID string `json:"version-id,omitempty"`
+	Parts     int    `json:"parts"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// Streamer uploads data as the object using multipart upload. The data is cut into parts which are uploaded in the
+// background, so the memory usage is limited by part size multiplied by concurrency. The upload is completed on
+// Finish and aborted on Close without Finish.
+type Streamer struct {
+	c         *http.Client
+	signer    *Signer
+	bucketURL string
+	objectURL string
+	partSize  int
+
+	uploadID        string
+	contentType     string
+	contentEncoding string
+	buf             []byte
+	partNumber      int
+	isCompleted     bool
+
+	sem   chan struct{}
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	parts []completedPart
+	err   error
+
+	report Report
+}
+
+// NewStreamer checks that the bucket is reachable with the credentials by HEAD request, so the unavailable storage
+// fails the creation of the streamer and the fallback output is selected instead of failing the download in the
+// middle. The credentials should allow s3:ListBucket for it. The upload itself is initiated with the header.
+func NewStreamer(address string, opts Options) (*Streamer, error) {
+	bucket, key, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Region == "" {
+		opts.Region = DefaultRegion
+	}
+
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://s3." + opts.Region + ".amazonaws.com"
+	}
+
+	if opts.PartSize <= 0 {
+		opts.PartSize = DefaultPartSize
+	}
+
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+
+	bucketURL := strings.TrimSuffix(opts.Endpoint, "/") + "/" + escapePath(bucket)
+
+	s := &Streamer{
+		c: &http.Client{
+			Transport: &http.Transport{
+				Proxy:       http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{Timeout: opts.DialTimeout}).DialContext,
+			},
+		},
+		signer:    NewSigner(opts.Credentials, opts.Region),
+		bucketURL: bucketURL,
+		objectURL: bucketURL + "/" + escapePath(key),
+		partSize:  opts.PartSize,
+
+		sem: make(chan struct{}, opts.Concurrency),
+	}
+
+	resp, err := s.request(http.MethodHead, s.bucketURL, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = resp.Body.Close()
+
+	return s, nil
+}
+
+// ParseAddress splits s3://bucket/key address.
+func ParseAddress(address string) (bucket string, key string, err error) {
+	if !strings.HasPrefix(address, Scheme) {
+		return "", "", ErrInvalidAddress
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(address, Scheme), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", ErrInvalidAddress
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func (s *Streamer) WriteHeader(m afd.Metadata) (err error) {
+	s.contentType, s.contentEncoding = m.ContentType, m.ContentEncoding
+
+	return s.initiate()
+}
+
+func (s *Streamer) Write(p []byte) (n int, err error) {
+	if err = s.initiate(); err != nil {
+		return 0, err
+	}
+
+	for len(p) > 0 {
+		if err = s.failure(); err != nil {
+			return n, err
+		}
+
+		chunk := p
+		if rest := s.partSize - len(s.buf); len(chunk) > rest {
+			chunk = chunk[:rest]
+		}
+
+		s.buf = append(s.buf, chunk...)
+		n += len(chunk)
+		p = p[len(chunk):]
+
+		if len(s.buf) == s.partSize {
+			s.uploadPart()
+		}
+	}
+
+	return n, nil
+}
+
+// Finish uploads the last part, waits for all parts and completes the upload.
+func (s *Streamer) Finish() (err error) {
+	if err = s.initiate(); err != nil {
+		return err
+	}
+
+	if len(s.buf) > 0 || s.partNumber == 0 {
+		s.uploadPart()
+	}
+
+	s.wg.Wait()
+
+	if err = s.failure(); err != nil {
+		return err
+	}
+
+	if err = s.complete(); err != nil {
+		return err
+	}
+
+	s.isCompleted = true
+
+	return nil
+}
+
+func (s *Streamer) Report() (report interface{}) {
+	return s.report
+}
+
+// Close aborts the upload if it was not completed, so the storage does not keep uploaded parts.
+func (s *Streamer) Close() (err error) {
+	s.wg.Wait()
+
+	if s.uploadID == "" || s.isCompleted {
+		return nil
+	}
+
+	resp, err := s.do(http.MethodDelete, url.Values{"uploadId": {s.uploadID}}.Encode(), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	_ = resp.Body.Close()
+
+	return nil
+}
+
+func (s *Streamer) initiate() (err error) {
+	if s.uploadID != "" {
+		return nil
+	}
+
+	header := http.Header{}
+	if s.contentType != "" {
+		header.Set("Content-Type", s.contentType)
+	}
+
+	if s.contentEncoding != "" {
+		header.Set("Content-Encoding", s.contentEncoding)
+	}
+
+	resp, err := s.do(http.MethodPost, "uploads", header, nil)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	result := struct {
+		UploadID string `xml:"UploadId"`
+	}{}
+
+	if err = xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	if result.UploadID == "" {
+		return fmt.Errorf("%w: empty upload id", ErrRequestFailed)
+	}
+
+	s.uploadID = result.UploadID
+
+	return nil
+}
+
+func (s *Streamer) uploadPart() {
+	s.partNumber++
+
+	var (
+		partNumber = s.partNumber
+		body       = s.buf
+	)
+
+	s.buf = make([]byte, 0, s.partSize)
+	s.sem <- struct{}{}
+	s.wg.Add(1)
+
+	go func() {
+		defer func() {
+			<-s.sem
+			s.wg.Done()
+		}()
+
+		resp, err := s.do(http.MethodPut, url.Values{
+			"partNumber": {strconv.Itoa(partNumber)},
+			"uploadId":   {s.uploadID},
+		}.Encode(), nil, body)
+		if err != nil {
+			s.fail(err)
+
+			return
+		}
+
+		_ = resp.Body.Close()
+
+		s.mu.Lock()
+		s.parts = append(s.parts, completedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+		s.mu.Unlock()
+	}()
+}
+
+func (s *Streamer) complete() (err error) {
+	sort.Slice(s.parts, func(i, j int) bool {
+		return s.parts[i].PartNumber < s.parts[j].PartNumber
+	})
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: s.parts})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(http.MethodPost, url.Values{"uploadId": {s.uploadID}}.Encode(), nil, body)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	// The storage could answer with 200 and report the error in the body.
+	result := struct {
+		XMLName xml.Name
+		ETag    string `xml:"ETag"`
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}{}
+
+	if err = xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("%w: %s %s", ErrRequestFailed, result.Code, result.Message)
+	}
+
+	s.report = Report{
+		ETag:      result.ETag,
+		VersionID: resp.Header.Get("X-Amz-Version-Id"),
+		Parts:     len(s.parts),
+	}
+
+	return nil
+}
+
+func (s *Streamer) do(method string, query string, header http.Header, body []byte) (*http.Response, error) {
+	return s.request(method, s.objectURL+"?"+query, header, body)
+}
+
+func (s *Streamer) request(method string, u string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(context.Background(), method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	payloadHash := EmptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = hashHex(body)
+	}
+
+	s.signer.Sign(req, payloadHash, time.Now())
+
+	resp, err := s.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		defer resp.Body.Close()
+
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+
+		return nil, fmt.Errorf("%w: %s %d %s", ErrRequestFailed, method, resp.StatusCode, msg)
+	}
+
+	return resp, nil
+}
+
+func (s *Streamer) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *Streamer) failure() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,320 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+type fakeStorage struct {
+	mu sync.Mutex
+
+	failPart    int
+	failPartial bool
+	noBucket    bool
+
+	contentType string
+	parts       map[int][]byte
+	object      []byte
+	isAborted   bool
+}
+
+func (fs *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), signAlgorithm+" Credential=access/") ||
+		(r.URL.Path != "/bucket/dir/index.json" && r.URL.Path != "/bucket") {
+		w.WriteHeader(http.StatusForbidden)
+
+		return
+	}
+
+	if r.URL.Path == "/bucket" {
+		if r.Method != http.MethodHead || fs.noBucket {
+			w.WriteHeader(http.StatusNotFound)
+		}
+
+		return
+	}
+
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPost && query["uploads"] != nil:
+		fs.contentType, fs.parts = r.Header.Get("Content-Type"), make(map[int][]byte)
+		_, _ = w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>1</UploadId></InitiateMultipartUploadResult>`))
+	case r.Method == http.MethodPut && query.Get("uploadId") == "1":
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		if partNumber == fs.failPart {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		fs.parts[partNumber] = body
+		sum := md5.Sum(body)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	case r.Method == http.MethodPost && query.Get("uploadId") == "1":
+		if fs.failPartial {
+			_, _ = w.Write([]byte(`<Error><Code>InvalidPart</Code><Message>invalid part</Message></Error>`))
+
+			return
+		}
+
+		req := struct {
+			Parts []completedPart `xml:"Part"`
+		}{}
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		for i, part := range req.Parts {
+			sum := md5.Sum(fs.parts[part.PartNumber])
+			if part.PartNumber != i+1 || part.ETag != `"`+hex.EncodeToString(sum[:])+`"` {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+
+			fs.object = append(fs.object, fs.parts[part.PartNumber]...)
+		}
+
+		w.Header().Set("X-Amz-Version-Id", "v1")
+		_, _ = w.Write([]byte(`<CompleteMultipartUploadResult><ETag>"etag-2"</ETag></CompleteMultipartUploadResult>`))
+	case r.Method == http.MethodDelete && query.Get("uploadId") == "1":
+		fs.isAborted = true
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestStreamer(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		storage *fakeStorage
+		body    []byte
+
+		wantErr bool
+
+		expectedObject  []byte
+		expectedReport  Report
+		expectedAborted bool
+	}{
+		{
+			name:    "pass",
+			enabled: true,
+
+			storage: &fakeStorage{},
+			body:    []byte(`{"a":1,"b":2}`),
+
+			expectedObject: []byte(`{"a":1,"b":2}`),
+			expectedReport: Report{ETag: `"etag-2"`, VersionID: "v1", Parts: 4},
+		},
+		{
+			name:    "empty object",
+			enabled: true,
+
+			storage: &fakeStorage{},
+
+			expectedReport: Report{ETag: `"etag-2"`, VersionID: "v1", Parts: 1},
+		},
+		{
+			name:    "upload part error",
+			enabled: true,
+
+			storage: &fakeStorage{failPart: 2},
+			body:    []byte(`{"a":1,"b":2}`),
+
+			wantErr: true,
+
+			expectedAborted: true,
+		},
+		{
+			name:    "complete error",
+			enabled: true,
+
+			storage: &fakeStorage{failPartial: true},
+			body:    []byte(`{}`),
+
+			wantErr: true,
+
+			expectedAborted: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			srv := httptest.NewServer(test.storage)
+			defer srv.Close()
+
+			s, err := NewStreamer("s3://bucket/dir/index.json", Options{
+				Endpoint:    srv.URL,
+				Credentials: Credentials{AccessKey: "access", SecretKey: "secret"},
+				PartSize:    4,
+				Concurrency: 2,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = s.WriteHeader(afd.Metadata{ContentType: "application/json"}); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = bytes.NewReader(test.body).WriteTo(s)
+			if err == nil {
+				err = s.Finish()
+			}
+
+			if (err != nil) != test.wantErr {
+				t.Error(err)
+				t.FailNow()
+			}
+
+			if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, "application/json", test.storage.contentType)
+			assert.Equal(t, test.expectedObject, test.storage.object)
+			assert.Equal(t, test.expectedAborted, test.storage.isAborted)
+
+			if !test.wantErr {
+				assert.Equal(t, test.expectedReport, s.Report())
+			}
+		})
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		address string
+
+		expectedBucket string
+		expectedKey    string
+		expectedErr    error
+	}{
+		{
+			name:    "pass",
+			enabled: true,
+
+			address: "s3://bucket/dir/index.json",
+
+			expectedBucket: "bucket",
+			expectedKey:    "dir/index.json",
+		},
+		{
+			name:    "without key",
+			enabled: true,
+
+			address: "s3://bucket/",
+
+			expectedErr: ErrInvalidAddress,
+		},
+		{
+			name:    "invalid scheme",
+			enabled: true,
+
+			address: "http://bucket/index.json",
+
+			expectedErr: ErrInvalidAddress,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			actualBucket, actualKey, err := ParseAddress(test.address)
+
+			assert.Equal(t, test.expectedErr, err)
+			assert.Equal(t, test.expectedBucket, actualBucket)
+			assert.Equal(t, test.expectedKey, actualKey)
+		})
+	}
+}
+
+func TestNewStreamer_Unavailable(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		storage     *fakeStorage
+		credentials Credentials
+		isClosed    bool
+	}{
+		{
+			name:    "no bucket",
+			enabled: true,
+
+			storage:     &fakeStorage{noBucket: true},
+			credentials: Credentials{AccessKey: "access", SecretKey: "secret"},
+		},
+		{
+			name:    "invalid credentials",
+			enabled: true,
+
+			storage:     &fakeStorage{},
+			credentials: Credentials{AccessKey: "invalid", SecretKey: "secret"},
+		},
+		{
+			name:    "unreachable storage",
+			enabled: true,
+
+			storage:     &fakeStorage{},
+			credentials: Credentials{AccessKey: "access", SecretKey: "secret"},
+			isClosed:    true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			srv := httptest.NewServer(test.storage)
+			defer srv.Close()
+
+			if test.isClosed {
+				srv.Close()
+			}
+
+			s, err := NewStreamer("s3://bucket/dir/index.json", Options{
+				Endpoint:    srv.URL,
+				Credentials: test.credentials,
+			})
+
+			assert.Error(t, err)
+			assert.Nil(t, s)
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package tcp
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+// Framed stream starts with FrameMagic which is followed by frames. Every frame is a frame type byte, payload
+// length as 4 bytes big-endian unsigned integer and payload. The first frame is FrameTypeHeader with JSON encoded
+// afd.Metadata, then body goes in FrameTypeData frames and the last frame is FrameTypeTrailer with JSON encoded
+// Trailer. Stream without trailer was truncated.
+const (
+	FrameMagic = "AFD\x01"
+
+	FrameTypeHeader  byte = 'H'
+	FrameTypeData    byte = 'D'
+	FrameTypeTrailer byte = 'T'
+
+	DefaultFrameSize = 64 * 1024
+	MaxFrameSize     = 16 * 1024 * 1024
+)
+
+var (
+	ErrHeaderAlreadySent = errors.New("stream error: frame header already sent")
+	ErrInvalidFrameMagic = errors.New("stream error: invalid frame magic")
+	ErrFrameTooLarge     = errors.New("stream error: frame too large")
+)
+
+type Trailer struct {
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+type FramedStreamer struct {
+	s afd.Streamer
+
+	frameSize  int
+	headerSent bool
+	written    int64
+	hash       hash.Hash
+}
+
+func NewFramedStreamer(s afd.Streamer, frameSize int) *FramedStreamer {
+	if frameSize <= 0 || frameSize > MaxFrameSize {
+		frameSize = DefaultFrameSize
+	}
+
+	return &FramedStreamer{
+		s: s,
+
+		frameSize: frameSize,
+		hash:      sha256.New(),
+	}
+}
+
+func (fs *FramedStreamer) WriteHeader(m afd.Metadata) (err error) {
+	if fs.headerSent {
+		return ErrHeaderAlreadySent
+	}
+
+	fs.headerSent = true
+
+	if hw, ok := fs.s.(afd.HeaderWriter); ok {
+		if err = hw.WriteHeader(m); err != nil {
+			return err
+		}
+	}
+
+	if _, err = io.WriteString(fs.s, FrameMagic); err != nil {
+		return err
+	}
+
+	return fs.writeJSONFrame(FrameTypeHeader, m)
+}
+
+func (fs *FramedStreamer) Write(p []byte) (n int, err error) {
+	if !fs.headerSent {
+		if err = fs.WriteHeader(afd.Metadata{ContentLength: -1}); err != nil {
+			return 0, err
+		}
+	}
+
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > fs.frameSize {
+			chunk = chunk[:fs.frameSize]
+		}
+
+		if err = fs.writeFrame(FrameTypeData, chunk); err != nil {
+			return n, err
+		}
+
+		_, _ = fs.hash.Write(chunk)
+		fs.written += int64(len(chunk))
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	return n, nil
+}
+
+func (fs *FramedStreamer) Finish() (err error) {
+	if !fs.headerSent {
+		if err = fs.WriteHeader(afd.Metadata{ContentLength: -1}); err != nil {
+			return err
+		}
+	}
+
+	if err = fs.writeJSONFrame(FrameTypeTrailer, Trailer{
+		Bytes:  fs.written,
+		SHA256: hex.EncodeToString(fs.hash.Sum(nil)),
+	}); err != nil {
+		return err
+	}
+
+	if f, ok := fs.s.(afd.Finisher); ok {
+		return f.Finish()
+	}
+
+	return nil
+}
+
+func (fs *FramedStreamer) Report() (report interface{}) {
+	if r, ok := fs.s.(afd.Reporter); ok {
+		return r.Report()
+	}
+
+	return nil
+}
+
+func (fs *FramedStreamer) Close() (err error) {
+	return fs.s.Close()
+}
+
+func (fs *FramedStreamer) writeJSONFrame(typ byte, v interface{}) (err error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return fs.writeFrame(typ, payload)
+}
+
+func (fs *FramedStreamer) writeFrame(typ byte, payload []byte) (err error) {
+	header := make([]byte, 5)
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+
+	if _, err = fs.s.Write(header); err != nil {
+		return err
+	}
+
+	if _, err = fs.s.Write(payload); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReadFrameMagic reads and checks the beginning of the framed stream.
+func ReadFrameMagic(r io.Reader) (err error) {
+	magic := make([]byte, len(FrameMagic))
+	if _, err = io.ReadFull(r, magic); err != nil {
+		return err
+	}
+
+	if string(magic) != FrameMagic {
+		return ErrInvalidFrameMagic
+	}
+
+	return nil
+}
+
+// ReadFrame reads the next frame of the framed stream.
+func ReadFrame(r io.Reader) (typ byte, payload []byte, err error) {
+	header := make([]byte, 5)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MaxFrameSize {
+		return 0, nil, ErrFrameTooLarge
+	}
+
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], payload, nil
+}
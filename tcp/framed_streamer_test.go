@@ -0,0 +1,223 @@
+package tcp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+type bufferStreamer struct {
+	bytes.Buffer
+}
+
+func (bs *bufferStreamer) Close() (err error) {
+	return nil
+}
+
+func TestFramedStreamer(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		frameSize int
+		header    *afd.Metadata
+		body      []byte
+		finish    bool
+
+		expectedHeader     afd.Metadata
+		expectedDataFrames int
+		expectedTrailer    *Trailer
+	}{
+		{
+			name:    "pass",
+			enabled: true,
+
+			frameSize: 2,
+			header: &afd.Metadata{
+				SourceURL:     "http://127.0.0.1:8080/index.html",
+				ContentType:   "application/json",
+				ContentLength: 5,
+				RequestID:     "1",
+			},
+			body:   []byte(`{"a"}`),
+			finish: true,
+
+			expectedHeader: afd.Metadata{
+				SourceURL:     "http://127.0.0.1:8080/index.html",
+				ContentType:   "application/json",
+				ContentLength: 5,
+				RequestID:     "1",
+			},
+			expectedDataFrames: 3,
+			expectedTrailer: &Trailer{
+				Bytes: 5,
+				SHA256: func() string {
+					sum := sha256.Sum256([]byte(`{"a"}`))
+
+					return hex.EncodeToString(sum[:])
+				}(),
+			},
+		},
+		{
+			name:    "header on first write",
+			enabled: true,
+
+			body:   []byte(`{}`),
+			finish: true,
+
+			expectedHeader: afd.Metadata{
+				ContentLength: -1,
+			},
+			expectedDataFrames: 1,
+			expectedTrailer: &Trailer{
+				Bytes: 2,
+				SHA256: func() string {
+					sum := sha256.Sum256([]byte(`{}`))
+
+					return hex.EncodeToString(sum[:])
+				}(),
+			},
+		},
+		{
+			name:    "truncated",
+			enabled: true,
+
+			body: []byte(`{}`),
+
+			expectedHeader: afd.Metadata{
+				ContentLength: -1,
+			},
+			expectedDataFrames: 1,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			buf := &bufferStreamer{}
+			fs := NewFramedStreamer(buf, test.frameSize)
+
+			if test.header != nil {
+				if err := fs.WriteHeader(*test.header); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			n, err := fs.Write(test.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, len(test.body), n)
+
+			if test.finish {
+				if err = fs.Finish(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err = ReadFrameMagic(buf); err != nil {
+				t.Fatal(err)
+			}
+
+			typ, payload, err := ReadFrame(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, FrameTypeHeader, typ)
+
+			var actualHeader afd.Metadata
+			if err = json.Unmarshal(payload, &actualHeader); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, test.expectedHeader, actualHeader)
+
+			var (
+				actualBody       []byte
+				actualDataFrames int
+				actualTrailer    *Trailer
+				readErr          error
+			)
+
+			for {
+				if typ, payload, readErr = ReadFrame(buf); readErr != nil {
+					break
+				}
+
+				if typ == FrameTypeData {
+					actualBody = append(actualBody, payload...)
+					actualDataFrames++
+
+					continue
+				}
+
+				actualTrailer = new(Trailer)
+				if err = json.Unmarshal(payload, actualTrailer); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			assert.True(t, errors.Is(readErr, io.EOF))
+			assert.Equal(t, test.body, actualBody)
+			assert.Equal(t, test.expectedDataFrames, actualDataFrames)
+			assert.Equal(t, test.expectedTrailer, actualTrailer)
+		})
+	}
+}
+
+func TestReadFrameMagic(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		in []byte
+
+		expected error
+	}{
+		{
+			name:    "pass",
+			enabled: true,
+
+			in: []byte(FrameMagic),
+		},
+		{
+			name:    "invalid magic",
+			enabled: true,
+
+			in: []byte(`{}{}`),
+
+			expected: ErrInvalidFrameMagic,
+		},
+		{
+			name:    "unexpected eof",
+			enabled: true,
+
+			in: []byte(`{}`),
+
+			expected: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			assert.Equal(t, test.expected, ReadFrameMagic(bytes.NewReader(test.in)))
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+const (
+	ListenScheme = "listen://"
+
+	DefaultListenTimeout = 30 * time.Second
+	DefaultAuthTimeout   = 5 * time.Second
+	MaxTokenSize         = 1024
+)
+
+var (
+	ErrListenTimeout = errors.New("stream error: no authorized client connected")
+	ErrUnauthorized  = errors.New("stream error: invalid client token")
+)
+
+// NewListenStreamer listens on the address and waits for the client which connects to us instead of dialing the
+// output. If the token is set the client should send it as the first line, clients with invalid token are
+// disconnected and the next one is awaited. The listener is closed as soon as the client is accepted.
+func NewListenStreamer(address string, opts Options) (afd.Streamer, error) {
+	if opts.FrameSize < 0 || opts.FrameSize > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	network, err := networkOf(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := opts.ListenTimeout
+	if timeout <= 0 {
+		timeout = DefaultListenTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	lc := &net.ListenConfig{KeepAlive: opts.KeepAlive}
+
+	l, err := lc.Listen(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	defer l.Close()
+
+	if err = l.(*net.TCPListener).SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrListenTimeout, err)
+		}
+
+		if err = authorize(c, opts.Token, deadline); err != nil {
+			_ = c.Close()
+
+			continue
+		}
+
+		return newStreamer(c, opts)
+	}
+}
+
+func authorize(c net.Conn, token string, deadline time.Time) (err error) {
+	if token == "" {
+		return nil
+	}
+
+	if authDeadline := time.Now().Add(DefaultAuthTimeout); authDeadline.Before(deadline) {
+		deadline = authDeadline
+	}
+
+	if err = c.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+
+	line, err := bufio.NewReader(io.LimitReader(c, MaxTokenSize)).ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(line)), []byte(token)) != 1 {
+		return ErrUnauthorized
+	}
+
+	return c.SetReadDeadline(time.Time{})
+}
@@ -0,0 +1,150 @@
+package tcp
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+func TestNewListenStreamer(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		opts    Options
+		clients []string
+
+		wantErr bool
+
+		expectedBody []byte
+	}{
+		{
+			name:    "pass",
+			enabled: true,
+
+			opts: Options{
+				ListenTimeout: time.Second,
+			},
+			clients: []string{""},
+
+			expectedBody: []byte(`{}`),
+		},
+		{
+			name:    "pass with token",
+			enabled: true,
+
+			opts: Options{
+				ListenTimeout: time.Second,
+				Token:         "secret",
+			},
+			clients: []string{"invalid\n", "secret\n"},
+
+			expectedBody: []byte(`{}`),
+		},
+		{
+			name:    "no authorized client",
+			enabled: true,
+
+			opts: Options{
+				ListenTimeout: 100 * time.Millisecond,
+				Token:         "secret",
+			},
+			clients: []string{"invalid\n"},
+
+			wantErr: true,
+		},
+		{
+			name:    "invalid frame size",
+			enabled: true,
+
+			opts: Options{
+				FrameSize: MaxFrameSize + 1,
+			},
+
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			var (
+				address  = freeAddress(t)
+				received = make(chan []byte, 1)
+			)
+
+			go func() {
+				for _, token := range test.clients {
+					var (
+						conn net.Conn
+						err  error
+					)
+
+					for i := 0; i < 50; i++ {
+						if conn, err = net.Dial("tcp", address); err == nil {
+							break
+						}
+
+						time.Sleep(10 * time.Millisecond)
+					}
+
+					if err != nil {
+						return
+					}
+
+					if _, err = conn.Write([]byte(token)); err != nil {
+						_ = conn.Close()
+
+						continue
+					}
+
+					body, _ := ioutil.ReadAll(conn)
+					_ = conn.Close()
+
+					if len(body) > 0 {
+						received <- body
+
+						return
+					}
+				}
+			}()
+
+			s, err := NewListenStreamer(address, test.opts)
+			if (err != nil) != test.wantErr {
+				t.Error(err)
+				t.FailNow()
+			}
+
+			if test.wantErr {
+				return
+			}
+
+			if _, err = s.Write([]byte(`{}`)); err != nil {
+				t.Fatal(err)
+			}
+
+			if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, test.expectedBody, <-received)
+		})
+	}
+}
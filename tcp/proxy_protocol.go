@@ -0,0 +1,140 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+const (
+	ProxyProtocolV1 = 1
+	ProxyProtocolV2 = 2
+)
+
+// PROXY protocol v2 TLV types. Source url has the type from the range which is reserved for applications.
+const (
+	PP2TypeAuthority = 0x02
+	PP2TypeUniqueID  = 0x05
+	PP2TypeSourceURL = 0xE0
+
+	maxUniqueIDLength = 128
+)
+
+// ProxyV2Signature starts PROXY protocol v2 header.
+const ProxyV2Signature = "\r\n\r\n\x00\r\nQUIT\n"
+
+var (
+	ErrInvalidProxyProtocol = errors.New("stream error: proxy protocol version should be 1 or 2")
+	ErrInvalidProxySource   = errors.New("stream error: proxy source address should be an ip address with a port")
+	ErrProxyHeaderTooLarge  = errors.New("stream error: proxy protocol header is too large")
+)
+
+// ProxyHeader builds PROXY protocol header, https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt, which
+// describes the connection from the source server, src, to the receiver, dst. If any of the addresses is not TCP
+// address the header says the connection is unknown. Header v2 also carries the source url and the request id from
+// the metadata as TLVs.
+func ProxyHeader(version int, src, dst net.Addr, m afd.Metadata) (header []byte, err error) {
+	srcAddr, srcOK := src.(*net.TCPAddr)
+	dstAddr, dstOK := dst.(*net.TCPAddr)
+	isKnown := srcOK && dstOK && srcAddr != nil && dstAddr != nil
+
+	switch version {
+	case ProxyProtocolV1:
+		return proxyHeaderV1(srcAddr, dstAddr, isKnown), nil
+	case ProxyProtocolV2:
+		return proxyHeaderV2(srcAddr, dstAddr, isKnown, m)
+	default:
+		return nil, ErrInvalidProxyProtocol
+	}
+}
+
+func proxyHeaderV1(src, dst *net.TCPAddr, isKnown bool) []byte {
+	if !isKnown {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+
+	family, srcIP, dstIP := "TCP4", src.IP.To4(), dst.IP.To4()
+	if srcIP == nil || dstIP == nil {
+		family, srcIP, dstIP = "TCP6", src.IP.To16(), dst.IP.To16()
+	}
+
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, srcIP, dstIP, src.Port, dst.Port))
+}
+
+func proxyHeaderV2(src, dst *net.TCPAddr, isKnown bool, m afd.Metadata) (header []byte, err error) {
+	var (
+		buf     = bytes.NewBufferString(ProxyV2Signature)
+		payload = new(bytes.Buffer)
+
+		command, family byte = 0x21, 0x00
+	)
+
+	switch {
+	case !isKnown:
+		// LOCAL command, the receiver uses the real connection addresses.
+		command = 0x20
+	case src.IP.To4() != nil && dst.IP.To4() != nil:
+		family = 0x11
+
+		payload.Write(src.IP.To4())
+		payload.Write(dst.IP.To4())
+	default:
+		family = 0x21
+
+		payload.Write(src.IP.To16())
+		payload.Write(dst.IP.To16())
+	}
+
+	if family != 0x00 {
+		_ = binary.Write(payload, binary.BigEndian, uint16(src.Port))
+		_ = binary.Write(payload, binary.BigEndian, uint16(dst.Port))
+	}
+
+	if u, err := url.Parse(m.SourceURL); err == nil && u.Host != "" {
+		writeTLV(payload, PP2TypeAuthority, []byte(u.Hostname()))
+	}
+
+	if m.SourceURL != "" {
+		writeTLV(payload, PP2TypeSourceURL, []byte(m.SourceURL))
+	}
+
+	if m.RequestID != "" && len(m.RequestID) <= maxUniqueIDLength {
+		writeTLV(payload, PP2TypeUniqueID, []byte(m.RequestID))
+	}
+
+	if payload.Len() > 0xFFFF {
+		return nil, ErrProxyHeaderTooLarge
+	}
+
+	buf.WriteByte(command)
+	buf.WriteByte(family)
+	_ = binary.Write(buf, binary.BigEndian, uint16(payload.Len()))
+	buf.Write(payload.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+func writeTLV(buf *bytes.Buffer, typ byte, value []byte) {
+	if len(value) > 0xFFFF {
+		return
+	}
+
+	buf.WriteByte(typ)
+	_ = binary.Write(buf, binary.BigEndian, uint16(len(value)))
+	buf.Write(value)
+}
+
+// parseProxySource parses the ip address with the port which overrides the source server address.
+func parseProxySource(address string) (addr *net.TCPAddr, err error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) == nil {
+		return nil, ErrInvalidProxySource
+	}
+
+	return net.ResolveTCPAddr("tcp", address)
+}
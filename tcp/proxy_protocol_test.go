@@ -0,0 +1,257 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+func TestProxyHeader(t *testing.T) {
+	var (
+		src4 = &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 443}
+		dst4 = &net.TCPAddr{IP: net.ParseIP("198.51.100.20"), Port: 5000}
+		src6 = &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 80}
+
+		m = afd.Metadata{
+			SourceURL: "https://files.example.com/data.csv",
+			RequestID: "42",
+		}
+	)
+
+	tt := []struct {
+		name    string
+		enabled bool
+
+		version  int
+		src, dst net.Addr
+
+		wantErr  bool
+		expected []byte
+	}{
+		{
+			name:    "v1 tcp4",
+			enabled: true,
+
+			version: ProxyProtocolV1,
+			src:     src4,
+			dst:     dst4,
+
+			expected: []byte("PROXY TCP4 192.0.2.10 198.51.100.20 443 5000\r\n"),
+		},
+		{
+			name:    "v1 tcp6 with mapped ipv4",
+			enabled: true,
+
+			version: ProxyProtocolV1,
+			src:     src6,
+			dst:     dst4,
+
+			expected: []byte("PROXY TCP6 2001:db8::1 198.51.100.20 80 5000\r\n"),
+		},
+		{
+			name:    "v1 unknown",
+			enabled: true,
+
+			version: ProxyProtocolV1,
+			dst:     dst4,
+
+			expected: []byte("PROXY UNKNOWN\r\n"),
+		},
+		{
+			name:    "v2 tcp4",
+			enabled: true,
+
+			version: ProxyProtocolV2,
+			src:     src4,
+			dst:     dst4,
+
+			expected: concat(
+				[]byte(ProxyV2Signature),
+				[]byte{0x21, 0x11, 0x00, 0x4a},
+				[]byte{192, 0, 2, 10, 198, 51, 100, 20, 0x01, 0xbb, 0x13, 0x88},
+				[]byte{PP2TypeAuthority, 0x00, 0x11}, []byte("files.example.com"),
+				[]byte{PP2TypeSourceURL, 0x00, 0x22}, []byte("https://files.example.com/data.csv"),
+				[]byte{PP2TypeUniqueID, 0x00, 0x02}, []byte("42"),
+			),
+		},
+		{
+			name:    "v2 local",
+			enabled: true,
+
+			version: ProxyProtocolV2,
+			src:     &net.UnixAddr{Name: "/tmp/socket", Net: "unix"},
+			dst:     dst4,
+
+			expected: concat(
+				[]byte(ProxyV2Signature),
+				[]byte{0x20, 0x00, 0x00, 0x3e},
+				[]byte{PP2TypeAuthority, 0x00, 0x11}, []byte("files.example.com"),
+				[]byte{PP2TypeSourceURL, 0x00, 0x22}, []byte("https://files.example.com/data.csv"),
+				[]byte{PP2TypeUniqueID, 0x00, 0x02}, []byte("42"),
+			),
+		},
+		{
+			name:    "invalid version",
+			enabled: true,
+
+			version: 3,
+			src:     src4,
+			dst:     dst4,
+
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			actual, err := ProxyHeader(test.version, test.src, test.dst, m)
+			if (err != nil) != test.wantErr {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// captureServer accepts one connection and sends everything which was received into the channel.
+func captureServer(t *testing.T) (address string, received chan []byte) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received = make(chan []byte, 1)
+
+	go func() {
+		defer l.Close()
+
+		c, err := l.Accept()
+		if err != nil {
+			received <- nil
+
+			return
+		}
+
+		defer c.Close()
+
+		b, _ := ioutil.ReadAll(c)
+		received <- b
+	}()
+
+	return l.Addr().String(), received
+}
+
+func TestStreamer_ProxyProtocol(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		opts   Options
+		header *afd.Metadata
+
+		expectedPrefix string
+	}{
+		{
+			name:    "source address from metadata",
+			enabled: true,
+
+			opts:   Options{ProxyProtocol: ProxyProtocolV1},
+			header: &afd.Metadata{SourceAddress: "192.0.2.10:443"},
+
+			expectedPrefix: "PROXY TCP4 192.0.2.10 127.0.0.1 443 ",
+		},
+		{
+			name:    "source address from options",
+			enabled: true,
+
+			opts:   Options{ProxyProtocol: ProxyProtocolV1, ProxySource: "192.0.2.11:8080"},
+			header: &afd.Metadata{SourceAddress: "192.0.2.10:443"},
+
+			expectedPrefix: "PROXY TCP4 192.0.2.11 127.0.0.1 8080 ",
+		},
+		{
+			name:    "without header",
+			enabled: true,
+
+			opts: Options{ProxyProtocol: ProxyProtocolV1},
+
+			expectedPrefix: "PROXY UNKNOWN\r\n",
+		},
+		{
+			name:    "framed",
+			enabled: true,
+
+			opts:   Options{ProxyProtocol: ProxyProtocolV2, Framing: true},
+			header: &afd.Metadata{SourceAddress: "192.0.2.10:443", RequestID: "42"},
+
+			expectedPrefix: ProxyV2Signature + "\x21\x11",
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			address, received := captureServer(t)
+
+			s, err := NewStreamer(address, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.header != nil {
+				if err = s.(afd.HeaderWriter).WriteHeader(*test.header); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err = io.WriteString(s, `{}`); err != nil {
+				t.Fatal(err)
+			}
+
+			if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			actual := <-received
+
+			assert.True(t, bytes.HasPrefix(actual, []byte(test.expectedPrefix)), "%q", actual)
+
+			if test.opts.ProxyProtocol == ProxyProtocolV2 {
+				size := binary.BigEndian.Uint16(actual[len(ProxyV2Signature)+2:])
+				actual = actual[len(ProxyV2Signature)+4+int(size):]
+
+				assert.True(t, bytes.HasPrefix(actual, []byte(FrameMagic)))
+
+				return
+			}
+
+			assert.True(t, bytes.HasSuffix(actual, []byte("\r\n{}")), "%q", actual)
+		})
+	}
+}
+
+func TestNewStreamer_InvalidProxyOptions(t *testing.T) {
+	address, _ := captureServer(t)
+
+	_, err := NewStreamer(address, Options{ProxyProtocol: 3})
+	assert.Equal(t, ErrInvalidProxyProtocol, err)
+}
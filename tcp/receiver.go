@@ -0,0 +1,424 @@
+package tcp
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+const (
+	RecordStatusOK    = "ok"
+	RecordStatusError = "error"
+
+	maxNameLength = 128
+	maxNameTries  = 1000
+)
+
+var (
+	ErrTruncatedStream  = errors.New("receive error: stream truncated")
+	ErrChecksumMismatch = errors.New("receive error: checksum mismatch")
+	ErrUnexpectedFrame  = errors.New("receive error: unexpected frame")
+	ErrNoFreeName       = errors.New("receive error: could not find free file name")
+	ErrDecryptionFailed = errors.New("receive error: decryption failed")
+	ErrEmptyStream      = errors.New("receive error: raw stream closed before any data")
+)
+
+// DecryptFunc returns reader of the data decrypted from r.
+type DecryptFunc func(r io.Reader) (io.Reader, error)
+
+// Record describes the result of receiving one stream.
+type Record struct {
+	Time        time.Time `json:"time"`
+	Remote      string    `json:"remote,omitempty"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	File        string    `json:"file,omitempty"`
+	Bytes       int64     `json:"bytes"`
+	SHA256      string    `json:"sha256,omitempty"`
+	Framed      bool      `json:"framed"`
+	Decrypted   bool      `json:"decrypted,omitempty"`
+	FileBytes   int64     `json:"file-bytes,omitempty"`
+	SourceURL   string    `json:"source-url,omitempty"`
+	ContentType string    `json:"content-type,omitempty"`
+	RequestID   string    `json:"request-id,omitempty"`
+}
+
+// Receiver is the counterpart of the streamer. It accepts streams, raw or framed, and stores them into the
+// directory. Framed streams are checked against the trailer, so truncated or corrupted streams are not stored.
+// Streams are decrypted before storing if decrypt is not nil, the trailer is checked against the received data.
+type Receiver struct {
+	dir         string
+	isAck       bool
+	readTimeout time.Duration
+	decrypt     DecryptFunc
+}
+
+func NewReceiver(dir string, isAck bool, readTimeout time.Duration, decrypt DecryptFunc) *Receiver {
+	return &Receiver{
+		dir:         dir,
+		isAck:       isAck,
+		readTimeout: readTimeout,
+		decrypt:     decrypt,
+	}
+}
+
+// Serve accepts connections until the listener is closed and calls log with the record of every received stream.
+func (r *Receiver) Serve(l net.Listener, log func(rec Record)) (err error) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func(c net.Conn) {
+			log(r.Receive(c))
+		}(c)
+	}
+}
+
+// Receive reads the stream from the connection, stores it and sends the acknowledgement if it is enabled. The
+// connection is closed after that.
+func (r *Receiver) Receive(c net.Conn) (rec Record) {
+	defer c.Close()
+
+	rec = Record{Time: time.Now().UTC(), Status: RecordStatusOK}
+
+	if c.RemoteAddr() != nil {
+		rec.Remote = c.RemoteAddr().String()
+	}
+
+	if err := r.receive(&deadlineReader{c: c, timeout: r.readTimeout}, &rec); err != nil {
+		rec.Status, rec.Error = RecordStatusError, err.Error()
+	}
+
+	if r.isAck {
+		_ = json.NewEncoder(c).Encode(ack(rec))
+	}
+
+	return rec
+}
+
+func ack(rec Record) interface{} {
+	if rec.Status != RecordStatusOK {
+		return struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		}{OK: false, Error: rec.Error}
+	}
+
+	return struct {
+		OK     bool   `json:"ok"`
+		File   string `json:"file"`
+		Bytes  int64  `json:"bytes"`
+		SHA256 string `json:"sha256"`
+	}{OK: true, File: rec.File, Bytes: rec.Bytes, SHA256: rec.SHA256}
+}
+
+func (r *Receiver) receive(c io.Reader, rec *Record) (err error) {
+	var (
+		br = bufio.NewReader(c)
+		m  = afd.Metadata{ContentLength: -1}
+	)
+
+	magic, err := br.Peek(len(FrameMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	// The raw stream has no end marker, so the connection which is closed before any data is the failed transfer
+	// rather than the empty file.
+	if len(magic) == 0 {
+		return ErrEmptyStream
+	}
+
+	if rec.Framed = string(magic) == FrameMagic; rec.Framed {
+		if m, err = readHeader(br); err != nil {
+			return err
+		}
+
+		rec.SourceURL, rec.ContentType, rec.RequestID = m.SourceURL, m.ContentType, m.RequestID
+	}
+
+	f, err := r.create(m)
+	if err != nil {
+		return err
+	}
+
+	var (
+		h  = sha256.New()
+		w  = io.Writer(f)
+		dw *decryptingWriter
+	)
+
+	if rec.Decrypted = r.decrypt != nil; rec.Decrypted {
+		dw = newDecryptingWriter(f, r.decrypt)
+		w = dw
+	}
+
+	if rec.Framed {
+		rec.Bytes, err = copyFrames(io.MultiWriter(w, h), br, h)
+	} else {
+		rec.Bytes, err = io.Copy(io.MultiWriter(w, h), br)
+	}
+
+	rec.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	if dw != nil {
+		// The failed decryption makes the copy fail with the same error.
+		if decryptErr := dw.close(err); decryptErr != nil && (err == nil || errors.Is(err, decryptErr)) {
+			err = fmt.Errorf("%w: %v", ErrDecryptionFailed, decryptErr)
+		}
+
+		rec.FileBytes = dw.n
+	}
+
+	if err != nil {
+		f.abort()
+
+		return err
+	}
+
+	if rec.File, err = f.commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func readHeader(r io.Reader) (m afd.Metadata, err error) {
+	if err = ReadFrameMagic(r); err != nil {
+		return m, err
+	}
+
+	typ, payload, err := ReadFrame(r)
+	if err != nil {
+		return m, err
+	}
+
+	if typ != FrameTypeHeader {
+		return m, fmt.Errorf("%w: %q", ErrUnexpectedFrame, typ)
+	}
+
+	if err = json.Unmarshal(payload, &m); err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
+
+// copyFrames copies data frames into w until the trailer, which is checked against h. The caller should make w
+// write into h as well.
+func copyFrames(w io.Writer, r io.Reader, h hash.Hash) (written int64, err error) {
+	for {
+		typ, payload, err := ReadFrame(r)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return written, ErrTruncatedStream
+		}
+
+		if err != nil {
+			return written, err
+		}
+
+		switch typ {
+		case FrameTypeData:
+			n, err := w.Write(payload)
+			if written += int64(n); err != nil {
+				return written, err
+			}
+		case FrameTypeTrailer:
+			t := Trailer{}
+			if err = json.Unmarshal(payload, &t); err != nil {
+				return written, err
+			}
+
+			if t.Bytes != written || t.SHA256 != hex.EncodeToString(h.Sum(nil)) {
+				return written, ErrChecksumMismatch
+			}
+
+			return written, nil
+		default:
+			return written, fmt.Errorf("%w: %q", ErrUnexpectedFrame, typ)
+		}
+	}
+}
+
+// decryptingWriter decrypts the data written into it on the fly and writes the result into the file.
+type decryptingWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+	n    int64
+}
+
+func newDecryptingWriter(w io.Writer, decrypt DecryptFunc) *decryptingWriter {
+	var (
+		pr, pw = io.Pipe()
+
+		dw = &decryptingWriter{pw: pw, done: make(chan error, 1)}
+	)
+
+	go func() {
+		dr, err := decrypt(pr)
+		if err == nil {
+			dw.n, err = io.Copy(w, dr)
+		}
+
+		// Unblocks the writer if decryption stopped before the end of the data.
+		_ = pr.CloseWithError(err)
+		dw.done <- err
+	}()
+
+	return dw
+}
+
+func (dw *decryptingWriter) Write(p []byte) (n int, err error) {
+	return dw.pw.Write(p)
+}
+
+// close ends the encrypted data, with the error if the stream was not received completely, and waits for the
+// decryption result.
+func (dw *decryptingWriter) close(err error) error {
+	if err != nil {
+		_ = dw.pw.CloseWithError(err)
+	} else {
+		_ = dw.pw.Close()
+	}
+
+	return <-dw.done
+}
+
+// receivedFile is written into the hidden temporary file which is renamed to the reserved name on commit.
+type receivedFile struct {
+	*os.File
+
+	name string
+}
+
+func (r *Receiver) create(m afd.Metadata) (f *receivedFile, err error) {
+	name := safeName(m)
+
+	for i := 0; i < maxNameTries; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = name + "." + strconv.Itoa(i)
+		}
+
+		reserved, err := os.OpenFile(filepath.Join(r.dir, candidate), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		_ = reserved.Close()
+
+		tmp, err := os.OpenFile(filepath.Join(r.dir, "."+candidate+".part"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY,
+			0o600)
+		if err != nil {
+			_ = os.Remove(reserved.Name())
+
+			return nil, err
+		}
+
+		return &receivedFile{File: tmp, name: reserved.Name()}, nil
+	}
+
+	return nil, ErrNoFreeName
+}
+
+func (f *receivedFile) commit() (name string, err error) {
+	if err = f.Close(); err != nil {
+		f.abort()
+
+		return "", err
+	}
+
+	if err = os.Rename(f.File.Name(), f.name); err != nil {
+		f.abort()
+
+		return "", err
+	}
+
+	return f.name, nil
+}
+
+func (f *receivedFile) abort() {
+	_ = f.Close()
+	_ = os.Remove(f.File.Name())
+	_ = os.Remove(f.name)
+}
+
+// safeName builds the file name from the request id, or the current time, and the last element of the source url
+// path. Everything except letters, digits, dots, dashes and underscores is replaced, so the name could not escape
+// the directory.
+func safeName(m afd.Metadata) string {
+	base := ""
+
+	if u, err := url.Parse(m.SourceURL); err == nil && m.SourceURL != "" {
+		base = path.Base(u.Path)
+	}
+
+	if m.FileName != "" {
+		base = path.Base(m.FileName)
+	}
+
+	if base = sanitizeName(base); base == "" {
+		base = "stream"
+	}
+
+	prefix := sanitizeName(m.RequestID)
+	if prefix == "" {
+		prefix = time.Now().UTC().Format("20060102T150405.000000000Z")
+	}
+
+	return prefix + "-" + base
+}
+
+func sanitizeName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+
+	if s = strings.TrimLeft(s, "."); len(s) > maxNameLength {
+		s = s[:maxNameLength]
+	}
+
+	return s
+}
+
+// deadlineReader extends the read deadline before every read, so the stalled sender does not hang the receiver.
+type deadlineReader struct {
+	c       net.Conn
+	timeout time.Duration
+}
+
+func (dr *deadlineReader) Read(p []byte) (n int, err error) {
+	if dr.timeout > 0 {
+		if err = dr.c.SetReadDeadline(time.Now().Add(dr.timeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	return dr.c.Read(p)
+}
@@ -0,0 +1,330 @@
+package tcp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+	"github.com/morozovcookie/afifiledownloader/transform"
+)
+
+func TestReceiver_Receive(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypt := func(r io.Reader) (io.Reader, error) {
+		return transform.NewDecryptor(r, []age.Identity{identity})
+	}
+
+	sendEncrypted := func(t *testing.T, s afd.Streamer) {
+		e, err := transform.NewEncryptor(s, []string{identity.Recipient().String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = e.Write([]byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = e.Finish(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tt := []struct {
+		name    string
+		enabled bool
+
+		opts    Options
+		header  *afd.Metadata
+		send    func(t *testing.T, s afd.Streamer)
+		decrypt DecryptFunc
+
+		wantErr bool
+
+		expectedStatus    string
+		expectedFramed    bool
+		expectedDecrypted bool
+		expectedName      string
+		expectedBody      []byte
+	}{
+		{
+			name:    "raw stream",
+			enabled: true,
+
+			send: func(t *testing.T, s afd.Streamer) {
+				if _, err := s.Write([]byte(`{}`)); err != nil {
+					t.Fatal(err)
+				}
+			},
+
+			expectedStatus: RecordStatusOK,
+			expectedName:   "-stream",
+			expectedBody:   []byte(`{}`),
+		},
+		{
+			name:    "empty raw stream",
+			enabled: true,
+
+			send: func(t *testing.T, s afd.Streamer) {},
+
+			wantErr: true,
+
+			expectedStatus: RecordStatusError,
+		},
+		{
+			name:    "framed stream with ack",
+			enabled: true,
+
+			opts: Options{
+				Framing: true,
+				Ack:     true,
+			},
+			header: &afd.Metadata{
+				SourceURL:     "http://127.0.0.1:8080/files/../index.json?a=b",
+				ContentType:   "application/json",
+				ContentLength: 2,
+				RequestID:     "1/2",
+			},
+			send: func(t *testing.T, s afd.Streamer) {
+				if _, err := s.Write([]byte(`{}`)); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := s.(afd.Finisher).Finish(); err != nil {
+					t.Fatal(err)
+				}
+			},
+
+			expectedStatus: RecordStatusOK,
+			expectedFramed: true,
+			expectedName:   "1_2-index.json",
+			expectedBody:   []byte(`{}`),
+		},
+		{
+			name:    "framed archive entry",
+			enabled: true,
+
+			opts: Options{
+				Framing: true,
+			},
+			header: &afd.Metadata{
+				SourceURL:     "http://127.0.0.1:8080/bundle.tar.gz",
+				ContentLength: 2,
+				RequestID:     "1",
+				FileName:      "data/a.json",
+			},
+			send: func(t *testing.T, s afd.Streamer) {
+				if _, err := s.Write([]byte(`{}`)); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := s.(afd.Finisher).Finish(); err != nil {
+					t.Fatal(err)
+				}
+			},
+
+			expectedStatus: RecordStatusOK,
+			expectedFramed: true,
+			expectedName:   "1-a.json",
+			expectedBody:   []byte(`{}`),
+		},
+		{
+			name:    "truncated framed stream",
+			enabled: true,
+
+			opts: Options{
+				Framing: true,
+			},
+			send: func(t *testing.T, s afd.Streamer) {
+				if _, err := s.Write([]byte(`{}`)); err != nil {
+					t.Fatal(err)
+				}
+			},
+
+			wantErr: true,
+
+			expectedStatus: RecordStatusError,
+			expectedFramed: true,
+		},
+		{
+			name:    "decrypted framed stream",
+			enabled: true,
+
+			opts: Options{
+				Framing: true,
+				Ack:     true,
+			},
+			send:    sendEncrypted,
+			decrypt: decrypt,
+
+			expectedStatus:    RecordStatusOK,
+			expectedFramed:    true,
+			expectedDecrypted: true,
+			expectedName:      "-stream",
+			expectedBody:      []byte(`{}`),
+		},
+		{
+			name:    "decrypted raw stream",
+			enabled: true,
+
+			send:    sendEncrypted,
+			decrypt: decrypt,
+
+			expectedStatus:    RecordStatusOK,
+			expectedDecrypted: true,
+			expectedName:      "-stream",
+			expectedBody:      []byte(`{}`),
+		},
+		{
+			name:    "not encrypted stream",
+			enabled: true,
+
+			send: func(t *testing.T, s afd.Streamer) {
+				if _, err := s.Write([]byte(`{}`)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			decrypt: decrypt,
+
+			wantErr: true,
+
+			expectedStatus:    RecordStatusError,
+			expectedDecrypted: true,
+		},
+		{
+			name:    "truncated encrypted stream",
+			enabled: true,
+
+			send: func(t *testing.T, s afd.Streamer) {
+				e, err := transform.NewEncryptor(s, []string{identity.Recipient().String()})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err = e.Write(bytes.Repeat([]byte(`a`), 128*1024)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			decrypt: decrypt,
+
+			wantErr: true,
+
+			expectedStatus:    RecordStatusError,
+			expectedDecrypted: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer l.Close()
+
+			var (
+				dir     = t.TempDir()
+				records = make(chan Record, 1)
+			)
+
+			go func() {
+				_ = NewReceiver(dir, test.opts.Ack, time.Second, test.decrypt).Serve(l, func(rec Record) {
+					records <- rec
+				})
+			}()
+
+			s, err := NewStreamer(l.Addr().String(), test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.header != nil {
+				if err = s.(afd.HeaderWriter).WriteHeader(*test.header); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			test.send(t, s)
+
+			if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			rec := <-records
+
+			assert.Equal(t, test.expectedStatus, rec.Status)
+			assert.Equal(t, test.expectedFramed, rec.Framed)
+			assert.Equal(t, test.expectedDecrypted, rec.Decrypted)
+			assert.Equal(t, test.wantErr, rec.Error != "")
+
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.wantErr {
+				assert.Empty(t, files)
+
+				return
+			}
+
+			assert.Len(t, files, 1)
+			assert.Equal(t, filepath.Join(dir, files[0].Name()), rec.File)
+			assert.Contains(t, rec.File, test.expectedName)
+
+			body, err := ioutil.ReadFile(rec.File)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, test.expectedBody, body)
+
+			if test.expectedDecrypted {
+				assert.Equal(t, int64(len(test.expectedBody)), rec.FileBytes)
+
+				return
+			}
+
+			assert.Equal(t, int64(len(test.expectedBody)), rec.Bytes)
+		})
+	}
+}
+
+func TestCopyFrames(t *testing.T) {
+	buf := &bufferStreamer{}
+	fs := NewFramedStreamer(buf, 0)
+
+	if _, err := fs.Write([]byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.writeJSONFrame(FrameTypeTrailer, Trailer{Bytes: 2, SHA256: "invalid"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readHeader(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	h := sha256.New()
+
+	_, err := copyFrames(io.MultiWriter(ioutil.Discard, h), buf, h)
+	assert.Equal(t, ErrChecksumMismatch, err)
+}
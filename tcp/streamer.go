@@ -1,32 +1,451 @@
 package tcp
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"os"
+	"strings"
+	"time"
 
 	afd "github.com/morozovcookie/afifiledownloader"
 )
 
+var (
+	ErrInvalidLocalAddress = errors.New("stream error: invalid local address")
+	ErrAckNotSupported     = errors.New("stream error: connection does not support acknowledgement")
+	ErrAckMissing          = errors.New("stream error: acknowledgement missing")
+	ErrNegativeAck         = errors.New("stream error: negative acknowledgement")
+	ErrInvalidNetwork      = errors.New("stream error: network should be tcp4 or tcp6")
+)
+
+const (
+	DefaultAckTimeout = 5 * time.Second
+	MaxAckSize        = 4096
+	DefaultBufferSize = 32 * 1024
+)
+
+type Options struct {
+	// DialTimeout limits the time of establishing connection. Zero means no timeout.
+	DialTimeout time.Duration
+
+	// WriteTimeout limits the time of every single write. Zero means no timeout.
+	WriteTimeout time.Duration
+
+	// IdleTimeout limits the time between the end of the previous write and the end of the next one, so it also
+	// covers the time which was spent waiting for the data. Zero means no timeout.
+	IdleTimeout time.Duration
+
+	// KeepAlive is the interval between keep-alive probes. Zero means the system default, negative value disables
+	// keep-alive.
+	KeepAlive time.Duration
+
+	// NoDelay sets TCP_NODELAY option if it is not nil.
+	NoDelay *bool
+
+	// SendBuffer sets SO_SNDBUF option if it is positive.
+	SendBuffer int
+
+	// Network restricts the address family of the connection, "tcp4" or "tcp6". The host name of the address is
+	// resolved into the addresses of the family only. Empty means both families.
+	Network string
+
+	// LocalAddress is the local ip address, with an optional port, which the connection is bound to.
+	LocalAddress string
+
+	// Framing enables framed stream, see FramedStreamer.
+	Framing bool
+
+	// FrameSize is the max size of data frame payload. Zero means DefaultFrameSize.
+	FrameSize int
+
+	// Ack enables waiting for the receiver acknowledgement after all data was written, see Streamer.Finish.
+	Ack bool
+
+	// AckTimeout limits the time of waiting for the acknowledgement. Zero means DefaultAckTimeout.
+	AckTimeout time.Duration
+
+	// ListenTimeout limits the time of waiting for the authorized client, see NewListenStreamer. Zero means
+	// DefaultListenTimeout.
+	ListenTimeout time.Duration
+
+	// Token is the shared secret which the client connected to the listen streamer should send as the first line.
+	Token string
+
+	// ProxyProtocol is the version of PROXY protocol header which is sent before the data, see ProxyHeader. Zero
+	// disables the header.
+	ProxyProtocol int
+
+	// ProxySource is the ip address with the port which is sent in PROXY protocol header instead of the address of
+	// the source server.
+	ProxySource string
+
+	// BufferSize is the size of the buffer which is used by Streamer.ReadFrom when the data is copied in user space,
+	// see Streamer.ReadFrom. Zero means DefaultBufferSize.
+	BufferSize int
+}
+
+type AckReport struct {
+	Ack string `json:"ack"`
+}
+
+type conn interface {
+	io.WriteCloser
+
+	SetWriteDeadline(t time.Time) error
+}
+
+type ackConn interface {
+	io.Reader
+
+	CloseWrite() error
+	SetReadDeadline(t time.Time) error
+}
+
 type Streamer struct {
-	conn io.WriteCloser
+	conn conn
+
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+	lastWrite    time.Time
+
+	isAck      bool
+	ackTimeout time.Duration
+	ack        string
+
+	bufferSize int
+
+	proxyVersion int
+	proxySource  *net.TCPAddr
+	remoteAddr   net.Addr
+	isProxySent  bool
 }
 
-func NewStreamer(address string) (afd.Streamer, error) {
+func NewStreamer(address string, opts Options) (afd.Streamer, error) {
 	var (
-		s = &Streamer{}
+		d = &net.Dialer{
+			Timeout:   opts.DialTimeout,
+			KeepAlive: opts.KeepAlive,
+		}
 
+		c   net.Conn
 		err error
 	)
 
-	if s.conn, err = net.Dial("tcp", address); err != nil {
+	if opts.FrameSize < 0 || opts.FrameSize > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	network, err := networkOf(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.LocalAddress != "" {
+		if d.LocalAddr, err = resolveLocalAddress(opts.LocalAddress); err != nil {
+			return nil, err
+		}
+	}
+
+	if c, err = d.Dial(network, address); err != nil {
+		return nil, err
+	}
+
+	return newStreamer(c, opts)
+}
+
+// newStreamer creates streamer over the established connection. The connection is closed on error.
+func newStreamer(c net.Conn, opts Options) (afd.Streamer, error) {
+	if err := setSocketOptions(c, opts); err != nil {
+		_ = c.Close()
+
 		return nil, err
 	}
 
+	if opts.ProxyProtocol != 0 && opts.ProxyProtocol != ProxyProtocolV1 && opts.ProxyProtocol != ProxyProtocolV2 {
+		_ = c.Close()
+
+		return nil, ErrInvalidProxyProtocol
+	}
+
+	s := &Streamer{
+		conn: c,
+
+		writeTimeout: opts.WriteTimeout,
+		idleTimeout:  opts.IdleTimeout,
+
+		isAck:      opts.Ack,
+		ackTimeout: opts.AckTimeout,
+
+		bufferSize: opts.BufferSize,
+
+		proxyVersion: opts.ProxyProtocol,
+		remoteAddr:   c.RemoteAddr(),
+	}
+
+	if opts.ProxySource != "" {
+		var err error
+		if s.proxySource, err = parseProxySource(opts.ProxySource); err != nil {
+			_ = c.Close()
+
+			return nil, err
+		}
+	}
+
+	if s.bufferSize <= 0 {
+		s.bufferSize = DefaultBufferSize
+	}
+
+	if opts.Framing {
+		return NewFramedStreamer(s, opts.FrameSize), nil
+	}
+
 	return s, nil
 }
 
+// networkOf returns the network the connection is established over.
+func networkOf(opts Options) (network string, err error) {
+	switch opts.Network {
+	case "":
+		return "tcp", nil
+	case "tcp4", "tcp6":
+		return opts.Network, nil
+	default:
+		return "", ErrInvalidNetwork
+	}
+}
+
+func resolveLocalAddress(address string) (addr *net.TCPAddr, err error) {
+	if net.ParseIP(address) != nil {
+		address = net.JoinHostPort(address, "0")
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) == nil {
+		return nil, ErrInvalidLocalAddress
+	}
+
+	return net.ResolveTCPAddr("tcp", address)
+}
+
+func setSocketOptions(c net.Conn, opts Options) (err error) {
+	tcpConn, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	if opts.NoDelay != nil {
+		if err = tcpConn.SetNoDelay(*opts.NoDelay); err != nil {
+			return err
+		}
+	}
+
+	if opts.SendBuffer > 0 {
+		if err = tcpConn.SetWriteBuffer(opts.SendBuffer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteHeader sends PROXY protocol header if it is enabled. The address of the source server is taken from the
+// metadata unless it is set in the options.
+func (s *Streamer) WriteHeader(m afd.Metadata) (err error) {
+	return s.writeProxyHeader(m)
+}
+
+// writeProxyHeader sends PROXY protocol header once before any other data.
+func (s *Streamer) writeProxyHeader(m afd.Metadata) (err error) {
+	if s.proxyVersion == 0 || s.isProxySent {
+		return nil
+	}
+
+	s.isProxySent = true
+
+	var src net.Addr
+
+	if s.proxySource != nil {
+		src = s.proxySource
+	} else if addr, err := net.ResolveTCPAddr("tcp", m.SourceAddress); err == nil && addr.IP != nil {
+		src = addr
+	}
+
+	header, err := ProxyHeader(s.proxyVersion, src, s.remoteAddr, m)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.write(header)
+
+	return err
+}
+
 func (s *Streamer) Write(p []byte) (n int, err error) {
-	return s.conn.Write(p)
+	if err = s.writeProxyHeader(afd.Metadata{}); err != nil {
+		return 0, err
+	}
+
+	return s.write(p)
+}
+
+func (s *Streamer) write(p []byte) (n int, err error) {
+	if deadline := s.writeDeadline(); !deadline.IsZero() {
+		if err = s.conn.SetWriteDeadline(deadline); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = s.conn.Write(p)
+	s.lastWrite = time.Now()
+
+	return n, err
+}
+
+// ReadFrom copies the data from r into the connection, so io.Copy uses it instead of Write. If the streamer has no
+// write and idle timeouts and r is TCP or Unix connection or file, the connection copies the data by itself, on
+// Linux with splice or sendfile without user space buffers. The kernel copy applies only to such non-HTTP sources:
+// HTTP response body, which the downloader always streams, is copied through the buffer of BufferSize and the
+// deadline is set before every write.
+func (s *Streamer) ReadFrom(r io.Reader) (n int64, err error) {
+	if err = s.writeProxyHeader(afd.Metadata{}); err != nil {
+		return 0, err
+	}
+
+	if rf, ok := s.conn.(io.ReaderFrom); ok && s.writeTimeout <= 0 && s.idleTimeout <= 0 && isKernelCopySource(r) {
+		n, err = rf.ReadFrom(r)
+		s.lastWrite = time.Now()
+
+		return n, err
+	}
+
+	return io.CopyBuffer(writerOnly{s}, r, make([]byte, s.bufferSize))
+}
+
+// TCPConn returns the connection of the streamer or nil if it is not TCP connection.
+func (s *Streamer) TCPConn() *net.TCPConn {
+	c, _ := s.conn.(*net.TCPConn)
+
+	return c
+}
+
+// writerOnly hides ReadFrom of the streamer, so io.CopyBuffer does not call it back.
+type writerOnly struct {
+	io.Writer
+}
+
+// isKernelCopySource reports whether net.TCPConn.ReadFrom could copy the data from r without user space buffers.
+func isKernelCopySource(r io.Reader) bool {
+	if lr, ok := r.(*io.LimitedReader); ok {
+		r = lr.R
+	}
+
+	switch r.(type) {
+	case *net.TCPConn, *net.UnixConn, *os.File:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Streamer) writeDeadline() (deadline time.Time) {
+	if s.writeTimeout > 0 {
+		deadline = time.Now().Add(s.writeTimeout)
+	}
+
+	if s.idleTimeout <= 0 {
+		return deadline
+	}
+
+	// The idle time is counted from the first write, not from the dial, so the time of preflight or of waiting for
+	// the slow source is not counted.
+	if s.lastWrite.IsZero() {
+		s.lastWrite = time.Now()
+	}
+
+	if idle := s.lastWrite.Add(s.idleTimeout); deadline.IsZero() || idle.Before(deadline) {
+		deadline = idle
+	}
+
+	return deadline
+}
+
+// Finish waits for the receiver acknowledgement if it is enabled. The write side of the connection is closed, so
+// the receiver gets EOF, and then the single line is read. The line is positive acknowledgement if it is "OK" or
+// "ACK" or a JSON object with "ok" set to true or "status" set to "ok".
+func (s *Streamer) Finish() (err error) {
+	if err = s.writeProxyHeader(afd.Metadata{}); err != nil {
+		return err
+	}
+
+	if !s.isAck {
+		return nil
+	}
+
+	c, ok := s.conn.(ackConn)
+	if !ok {
+		return ErrAckNotSupported
+	}
+
+	if err = c.CloseWrite(); err != nil {
+		return err
+	}
+
+	timeout := s.ackTimeout
+	if timeout <= 0 {
+		timeout = DefaultAckTimeout
+	}
+
+	if err = c.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	line, err := bufio.NewReader(io.LimitReader(c, MaxAckSize)).ReadString('\n')
+	if s.ack = strings.TrimSpace(line); s.ack == "" {
+		if err == nil || errors.Is(err, io.EOF) {
+			return ErrAckMissing
+		}
+
+		return fmt.Errorf("%w: %v", ErrAckMissing, err)
+	}
+
+	if !isPositiveAck(s.ack) {
+		return fmt.Errorf("%w: %s", ErrNegativeAck, s.ack)
+	}
+
+	return nil
+}
+
+func isPositiveAck(ack string) bool {
+	if !strings.HasPrefix(ack, "{") {
+		return strings.EqualFold(ack, "ok") || strings.EqualFold(ack, "ack")
+	}
+
+	var v struct {
+		OK     *bool  `json:"ok"`
+		Status string `json:"status"`
+	}
+
+	if err := json.Unmarshal([]byte(ack), &v); err != nil {
+		return false
+	}
+
+	if v.OK != nil {
+		return *v.OK
+	}
+
+	return strings.EqualFold(v.Status, "ok")
+}
+
+func (s *Streamer) Report() (report interface{}) {
+	if !s.isAck {
+		return nil
+	}
+
+	return AckReport{Ack: s.ack}
 }
 
 func (s *Streamer) Close() (err error) {
@@ -3,9 +3,15 @@ package tcp
 import (
 	"errors"
 	afd "github.com/morozovcookie/afifiledownloader"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -17,6 +23,7 @@ func TestNewStreamerCreator(t *testing.T) {
 		enabled bool
 
 		address func(string) string
+		opts    Options
 
 		afterCreate func(t *testing.T, s afd.Streamer)
 
@@ -38,6 +45,101 @@ func TestNewStreamerCreator(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:    "pass with options",
+			enabled: true,
+
+			address: func(srv string) string {
+				return srv
+			},
+			opts: Options{
+				DialTimeout:  time.Second,
+				WriteTimeout: time.Second,
+				IdleTimeout:  time.Second,
+				KeepAlive:    time.Second,
+				NoDelay:      func(v bool) *bool { return &v }(false),
+				SendBuffer:   4096,
+				LocalAddress: "127.0.0.1",
+			},
+
+			afterCreate: func(t *testing.T, s afd.Streamer) {
+				assert.NotNil(t, s)
+
+				if err := s.Close(); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:    "invalid local address",
+			enabled: true,
+
+			address: func(srv string) string {
+				return srv
+			},
+			opts: Options{
+				LocalAddress: "localhost:0",
+			},
+
+			afterCreate: func(t *testing.T, s afd.Streamer) {
+				assert.Nil(t, s)
+			},
+
+			wantErr: true,
+		},
+		{
+			name:    "pass with network",
+			enabled: true,
+
+			address: func(srv string) string {
+				return srv
+			},
+			opts: Options{
+				Network: "tcp4",
+			},
+
+			afterCreate: func(t *testing.T, s afd.Streamer) {
+				assert.NotNil(t, s)
+
+				if err := s.Close(); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:    "address of other network",
+			enabled: true,
+
+			address: func(srv string) string {
+				return srv
+			},
+			opts: Options{
+				Network: "tcp6",
+			},
+
+			afterCreate: func(t *testing.T, s afd.Streamer) {
+				assert.Nil(t, s)
+			},
+
+			wantErr: true,
+		},
+		{
+			name:    "invalid network",
+			enabled: true,
+
+			address: func(srv string) string {
+				return srv
+			},
+			opts: Options{
+				Network: "udp",
+			},
+
+			afterCreate: func(t *testing.T, s afd.Streamer) {
+				assert.Nil(t, s)
+			},
+
+			wantErr: true,
+		},
 		{
 			name:    "create error",
 			enabled: true,
@@ -63,7 +165,7 @@ func TestNewStreamerCreator(t *testing.T) {
 			srv := httptest.NewServer(http.NewServeMux())
 			defer srv.Close()
 
-			s, err := NewStreamer(test.address(srv.Listener.Addr().String()))
+			s, err := NewStreamer(test.address(srv.Listener.Addr().String()), test.opts)
 			if (err != nil) != test.wantErr {
 				t.Error(err)
 				t.FailNow()
@@ -88,14 +190,20 @@ func (c *MockConn) Close() (err error) {
 	return c.Called().Error(0)
 }
 
+func (c *MockConn) SetWriteDeadline(t time.Time) (err error) {
+	return c.Called(t).Error(0)
+}
+
 func TestStreamer_Write(t *testing.T) {
 	tt := []struct {
 		name    string
 		enabled bool
 
-		conn        *MockConn
-		writeInput  []interface{}
-		writeOutput []interface{}
+		conn           *MockConn
+		writeInput     []interface{}
+		writeOutput    []interface{}
+		writeTimeout   time.Duration
+		deadlineOutput []interface{}
 
 		wantErr bool
 
@@ -116,6 +224,44 @@ func TestStreamer_Write(t *testing.T) {
 
 			expectedN: len([]byte(`{}`)),
 		},
+		{
+			name:    "pass with write timeout",
+			enabled: true,
+
+			conn: new(MockConn),
+			writeInput: []interface{}{
+				[]byte(`{}`),
+			},
+			writeOutput: []interface{}{
+				len([]byte(`{}`)),
+				(error)(nil),
+			},
+			writeTimeout: time.Second,
+			deadlineOutput: []interface{}{
+				(error)(nil),
+			},
+
+			expectedN: len([]byte(`{}`)),
+		},
+		{
+			name:    "set deadline error",
+			enabled: true,
+
+			conn: new(MockConn),
+			writeInput: []interface{}{
+				[]byte(`{}`),
+			},
+			writeOutput: []interface{}{
+				len([]byte(`{}`)),
+				(error)(nil),
+			},
+			writeTimeout: time.Second,
+			deadlineOutput: []interface{}{
+				errors.New("set deadline error"),
+			},
+
+			wantErr: true,
+		},
 		{
 			name:    "write error",
 			enabled: true,
@@ -142,8 +288,11 @@ func TestStreamer_Write(t *testing.T) {
 			test.conn.
 				On("Write", test.writeInput...).
 				Return(test.writeOutput...)
+			test.conn.
+				On("SetWriteDeadline", mock.AnythingOfType("time.Time")).
+				Return(test.deadlineOutput...)
 
-			s := &Streamer{conn: test.conn}
+			s := &Streamer{conn: test.conn, writeTimeout: test.writeTimeout}
 			actualN, err := s.Write([]byte(`{}`))
 			if (err != nil) != test.wantErr {
 				t.Error(err)
@@ -205,3 +354,575 @@ func TestStreamer_Close(t *testing.T) {
 		})
 	}
 }
+
+func TestStreamer_writeDeadline(t *testing.T) {
+	now := time.Now()
+
+	tt := []struct {
+		name    string
+		enabled bool
+
+		writeTimeout time.Duration
+		idleTimeout  time.Duration
+		lastWrite    time.Time
+
+		expectedZero   bool
+		expectedAfter  time.Time
+		expectedBefore time.Time
+	}{
+		{
+			name:    "no timeouts",
+			enabled: true,
+
+			expectedZero: true,
+		},
+		{
+			name:    "write timeout",
+			enabled: true,
+
+			writeTimeout: time.Minute,
+
+			expectedBefore: now.Add(2 * time.Minute),
+		},
+		{
+			name:    "idle timeout is earlier",
+			enabled: true,
+
+			writeTimeout: time.Hour,
+			idleTimeout:  time.Minute,
+			lastWrite:    now,
+
+			expectedBefore: now.Add(2 * time.Minute),
+		},
+		{
+			name:    "idle timeout before first write",
+			enabled: true,
+
+			idleTimeout: time.Minute,
+
+			expectedAfter:  now.Add(time.Minute),
+			expectedBefore: now.Add(2 * time.Minute),
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			s := &Streamer{writeTimeout: test.writeTimeout, idleTimeout: test.idleTimeout, lastWrite: test.lastWrite}
+			actual := s.writeDeadline()
+
+			assert.Equal(t, test.expectedZero, actual.IsZero())
+
+			if !test.expectedZero {
+				assert.True(t, actual.Before(test.expectedBefore))
+				assert.False(t, actual.Before(test.expectedAfter))
+			}
+		})
+	}
+}
+
+func TestStreamer_Finish(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		isAck      bool
+		ackTimeout time.Duration
+		receiver   func(conn net.Conn)
+
+		wantErr bool
+
+		expectedReport interface{}
+	}{
+		{
+			name:    "ack disabled",
+			enabled: true,
+
+			receiver: func(conn net.Conn) {
+				_, _ = io.Copy(ioutil.Discard, conn)
+			},
+		},
+		{
+			name:    "positive line ack",
+			enabled: true,
+
+			isAck: true,
+			receiver: func(conn net.Conn) {
+				_, _ = io.Copy(ioutil.Discard, conn)
+				_, _ = conn.Write([]byte("OK\n"))
+			},
+
+			expectedReport: AckReport{Ack: "OK"},
+		},
+		{
+			name:    "positive json ack",
+			enabled: true,
+
+			isAck: true,
+			receiver: func(conn net.Conn) {
+				_, _ = io.Copy(ioutil.Discard, conn)
+				_, _ = conn.Write([]byte(`{"ok":true,"bytes":2}`))
+			},
+
+			expectedReport: AckReport{Ack: `{"ok":true,"bytes":2}`},
+		},
+		{
+			name:    "negative ack",
+			enabled: true,
+
+			isAck: true,
+			receiver: func(conn net.Conn) {
+				_, _ = io.Copy(ioutil.Discard, conn)
+				_, _ = conn.Write([]byte(`{"ok":false,"error":"disk full"}` + "\n"))
+			},
+
+			wantErr: true,
+
+			expectedReport: AckReport{Ack: `{"ok":false,"error":"disk full"}`},
+		},
+		{
+			name:    "missing ack",
+			enabled: true,
+
+			isAck: true,
+			receiver: func(conn net.Conn) {
+				_, _ = io.Copy(ioutil.Discard, conn)
+			},
+
+			wantErr: true,
+
+			expectedReport: AckReport{},
+		},
+		{
+			name:    "ack timeout",
+			enabled: true,
+
+			isAck:      true,
+			ackTimeout: 50 * time.Millisecond,
+			receiver: func(conn net.Conn) {
+				_, _ = io.Copy(ioutil.Discard, conn)
+				time.Sleep(time.Second)
+			},
+
+			wantErr: true,
+
+			expectedReport: AckReport{},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer l.Close()
+
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+
+				defer conn.Close()
+
+				test.receiver(conn)
+			}()
+
+			s, err := NewStreamer(l.Addr().String(), Options{Ack: test.isAck, AckTimeout: test.ackTimeout})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer s.Close()
+
+			if _, err = s.Write([]byte(`{}`)); err != nil {
+				t.Fatal(err)
+			}
+
+			if err = s.(afd.Finisher).Finish(); (err != nil) != test.wantErr {
+				t.Error(err)
+				t.FailNow()
+			}
+
+			assert.Equal(t, test.expectedReport, s.(afd.Reporter).Report())
+		})
+	}
+}
+
+// discardServer accepts one connection and sends the number of received bytes into the channel.
+func discardServer(tb testing.TB) (address string, received chan int64) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	received = make(chan int64, 1)
+
+	go func() {
+		defer l.Close()
+
+		c, err := l.Accept()
+		if err != nil {
+			received <- -1
+
+			return
+		}
+
+		defer c.Close()
+
+		n, _ := io.Copy(ioutil.Discard, c)
+		received <- n
+	}()
+
+	return l.Addr().String(), received
+}
+
+// sourceConn returns connection which reads size bytes from the server.
+func sourceConn(tb testing.TB, size int64) net.Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	go func() {
+		defer l.Close()
+
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		defer c.Close()
+
+		_, _ = io.CopyN(c, zeroReader{}, size)
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return c
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (n int, err error) {
+	for i := range p {
+		p[i] = 0
+	}
+
+	return len(p), nil
+}
+
+func TestStreamer_ReadFrom(t *testing.T) {
+	const size = 4 * 1024 * 1024
+
+	tt := []struct {
+		name    string
+		enabled bool
+
+		opts Options
+	}{
+		{
+			name:    "kernel copy",
+			enabled: true,
+		},
+		{
+			name:    "buffer copy with write timeout",
+			enabled: true,
+
+			opts: Options{
+				WriteTimeout: time.Second,
+				BufferSize:   1024,
+			},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			address, received := discardServer(t)
+
+			s, err := NewStreamer(address, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.NotNil(t, s.(*Streamer).TCPConn())
+
+			src := sourceConn(t, size)
+			defer src.Close()
+
+			n, err := io.Copy(s, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, int64(size), n)
+			assert.Equal(t, int64(size), <-received)
+		})
+	}
+}
+
+func TestStreamer_ReadFromBuffer(t *testing.T) {
+	conn := new(MockConn)
+	conn.
+		On("Write", []byte(`{}`)).
+		Return(2, (error)(nil))
+	conn.
+		On("Write", []byte(`[`)).
+		Return(1, (error)(nil))
+	conn.
+		On("SetWriteDeadline", mock.AnythingOfType("time.Time")).
+		Return((error)(nil))
+
+	s := &Streamer{conn: conn, idleTimeout: time.Second, bufferSize: 2}
+
+	n, err := s.ReadFrom(struct{ io.Reader }{strings.NewReader(`{}[`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, int64(3), n)
+	assert.Nil(t, s.TCPConn())
+	conn.AssertNumberOfCalls(t, "Write", 2)
+}
+
+type readerFromConn struct {
+	MockConn
+}
+
+func (c *readerFromConn) ReadFrom(r io.Reader) (n int64, err error) {
+	args := c.Called(r)
+
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func TestStreamer_ReadFromBufferWithoutTimeouts(t *testing.T) {
+	conn := new(readerFromConn)
+	conn.
+		On("Write", []byte(`{}`)).
+		Return(2, (error)(nil))
+	conn.
+		On("Write", []byte(`[`)).
+		Return(1, (error)(nil))
+
+	s := &Streamer{conn: conn, bufferSize: 2}
+
+	// The body which is not a connection or a file could not be copied by the kernel, so the buffer size is used
+	// even without timeouts.
+	n, err := s.ReadFrom(struct{ io.Reader }{strings.NewReader(`{}[`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, int64(3), n)
+	conn.AssertNumberOfCalls(t, "Write", 2)
+	conn.AssertNotCalled(t, "ReadFrom", mock.Anything)
+}
+
+func TestStreamer_ReadFromKernel(t *testing.T) {
+	f, err := ioutil.TempFile("", "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	tt := []struct {
+		name    string
+		enabled bool
+
+		source io.Reader
+	}{
+		{
+			name:    "file",
+			enabled: true,
+
+			source: f,
+		},
+		{
+			name:    "limited file",
+			enabled: true,
+
+			source: io.LimitReader(f, 2),
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			conn := new(readerFromConn)
+			conn.
+				On("ReadFrom", test.source).
+				Return(int64(2), (error)(nil))
+
+			s := &Streamer{conn: conn, bufferSize: 2}
+
+			// The file source without timeouts is handed over to the connection, which copies it in the kernel.
+			n, err := s.ReadFrom(test.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, int64(2), n)
+			conn.AssertCalled(t, "ReadFrom", test.source)
+			conn.AssertNotCalled(t, "Write", mock.Anything)
+		})
+	}
+}
+
+// httpSource returns the body of the response with size bytes.
+func httpSource(tb testing.TB, size int64) io.ReadCloser {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.CopyN(w, zeroReader{}, size)
+	}))
+
+	res, err := http.Get(srv.URL) // nolint: noctx
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: res.Body,
+		Closer: closerFunc(func() error {
+			defer srv.Close()
+
+			return res.Body.Close()
+		}),
+	}
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// BenchmarkStreamer_ReadFrom compares the copy of TCP connection with ReadFrom, which lets the kernel copy the data,
+// with the copy through the buffer and with plain Write. HTTP response body is always copied through the buffer.
+func BenchmarkStreamer_ReadFrom(b *testing.B) {
+	const size = 64 * 1024 * 1024
+
+	var (
+		readFrom = func(s afd.Streamer, src io.Reader) (n int64, err error) {
+			return io.Copy(s, src)
+		}
+		write = func(s afd.Streamer, src io.Reader) (n int64, err error) {
+			return io.Copy(writerOnly{s}, src)
+		}
+		tcpSource = func(tb testing.TB, size int64) io.ReadCloser {
+			return sourceConn(tb, size)
+		}
+	)
+
+	bb := []struct {
+		name string
+
+		opts   Options
+		source func(tb testing.TB, size int64) io.ReadCloser
+		copy   func(s afd.Streamer, src io.Reader) (n int64, err error)
+	}{
+		{
+			name: "tcp kernel copy",
+
+			source: tcpSource,
+			copy:   readFrom,
+		},
+		{
+			name: "tcp buffer copy",
+
+			opts:   Options{IdleTimeout: time.Minute},
+			source: tcpSource,
+			copy:   readFrom,
+		},
+		{
+			name: "tcp buffer copy 256KiB",
+
+			opts:   Options{IdleTimeout: time.Minute, BufferSize: 256 * 1024},
+			source: tcpSource,
+			copy:   readFrom,
+		},
+		{
+			name: "tcp write",
+
+			source: tcpSource,
+			copy:   write,
+		},
+		{
+			name: "http buffer copy",
+
+			source: httpSource,
+			copy:   readFrom,
+		},
+		{
+			name: "http buffer copy 256KiB",
+
+			opts:   Options{IdleTimeout: time.Minute, BufferSize: 256 * 1024},
+			source: httpSource,
+			copy:   readFrom,
+		},
+		{
+			name: "http write",
+
+			source: httpSource,
+			copy:   write,
+		},
+	}
+
+	for _, bench := range bb {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(size)
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+
+				address, received := discardServer(b)
+
+				s, err := NewStreamer(address, bench.opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				src := bench.source(b, size)
+
+				b.StartTimer()
+
+				if _, err = bench.copy(s, src); err != nil {
+					b.Fatal(err)
+				}
+
+				_ = s.Close()
+				<-received
+
+				b.StopTimer()
+
+				_ = src.Close()
+			}
+		})
+	}
+}
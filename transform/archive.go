@@ -0,0 +1,176 @@
+package transform
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	ArchiveTar = "tar"
+	ArchiveZip = "zip"
+)
+
+var (
+	ErrUnsupportedArchive = errors.New("extract error: unsupported archive format")
+	ErrInvalidArchive     = errors.New("extract error: invalid archive")
+)
+
+var zipContentTypes = map[string]bool{
+	"application/zip":              true,
+	"application/x-zip-compressed": true,
+}
+
+// ArchiveEntry describes the regular file of the archive.
+type ArchiveEntry struct {
+	Name string
+	Size int64
+}
+
+// EntryFunc is called for every regular file of the archive with the reader of its content. The content, which
+// is not read, is skipped.
+type EntryFunc func(e ArchiveEntry, r io.Reader) (err error)
+
+// ArchiveFormat returns zip if the content type or, if the content type is generic, extension of the path says the
+// data is zip archive, otherwise tar.
+func ArchiveFormat(contentType string, filePath string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && zipContentTypes[mediaType] {
+		return ArchiveZip
+	}
+
+	if strings.ToLower(path.Ext(filePath)) == ".zip" {
+		return ArchiveZip
+	}
+
+	return ArchiveTar
+}
+
+// Extract calls fn for every regular file of the archive in the format, tar or zip.
+func Extract(format string, r io.Reader, dir string, fn EntryFunc) (err error) {
+	switch format {
+	case ArchiveTar:
+		return ExtractTar(r, fn)
+	case ArchiveZip:
+		return ExtractZip(r, dir, fn)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedArchive, format)
+	}
+}
+
+// ExtractTar reads tar archive as a stream. The archive compressed with gzip, zstd or bzip2 is recognized by its
+// magic bytes and decompressed on the fly.
+func ExtractTar(r io.Reader, fn EntryFunc) (err error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(4)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	var tr *tar.Reader
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		d, err := NewDecoder(br, []string{EncodingGzip})
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+
+		defer d.Close()
+
+		tr = tar.NewReader(d)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		d, err := NewDecoder(br, []string{EncodingZstd})
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+
+		defer d.Close()
+
+		tr = tar.NewReader(d)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		tr = tar.NewReader(bzip2.NewReader(br))
+	default:
+		tr = tar.NewReader(br)
+	}
+
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		if err = fn(ArchiveEntry{Name: cleanEntryName(h.Name), Size: h.Size}, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// ExtractZip spools zip archive into the temporary file in dir, or in the default directory for temporary files if
+// dir is empty, because the central directory is at the end of the archive. The file is removed after extraction.
+func ExtractZip(r io.Reader, dir string, fn EntryFunc) (err error) {
+	f, err := os.CreateTemp(dir, "afd-zip-*")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+
+		if err = extractZipFile(zf, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func extractZipFile(zf *zip.File, fn EntryFunc) (err error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	defer rc.Close()
+
+	return fn(ArchiveEntry{Name: cleanEntryName(zf.Name), Size: int64(zf.UncompressedSize64)}, rc)
+}
+
+// cleanEntryName returns the name of the entry as the relative slash separated path.
+func cleanEntryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+}
@@ -0,0 +1,219 @@
+package transform
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+var archiveFiles = []struct {
+	name string
+	body string
+}{
+	{name: "data/a.csv", body: "a,b\n1,2\n"},
+	{name: "README.md", body: "readme"},
+	{name: "data/empty.txt"},
+}
+
+func tarArchive(t *testing.T) []byte {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	if err := tw.WriteHeader(&tar.Header{Name: "data/", Typeflag: tar.TypeDir, Mode: 0o755}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range archiveFiles {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Size: int64(len(f.body)), Mode: 0o644}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write([]byte(f.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	if _, err := zw.Create("data/"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range archiveFiles {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = w.Write([]byte(f.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	gzipped := func(b []byte) []byte {
+		buf := new(bytes.Buffer)
+		w := gzip.NewWriter(buf)
+		_, _ = w.Write(b)
+		_ = w.Close()
+
+		return buf.Bytes()
+	}
+
+	zstded := func(b []byte) []byte {
+		buf := new(bytes.Buffer)
+		w, _ := zstd.NewWriter(buf)
+		_, _ = w.Write(b)
+		_ = w.Close()
+
+		return buf.Bytes()
+	}
+
+	tt := []struct {
+		name    string
+		enabled bool
+
+		format  string
+		archive []byte
+
+		wantErr  bool
+		expected error
+	}{
+		{
+			name:    "tar",
+			enabled: true,
+
+			format:  ArchiveTar,
+			archive: tarArchive(t),
+		},
+		{
+			name:    "tar gzip",
+			enabled: true,
+
+			format:  ArchiveTar,
+			archive: gzipped(tarArchive(t)),
+		},
+		{
+			name:    "tar zstd",
+			enabled: true,
+
+			format:  ArchiveTar,
+			archive: zstded(tarArchive(t)),
+		},
+		{
+			name:    "zip",
+			enabled: true,
+
+			format:  ArchiveZip,
+			archive: zipArchive(t),
+		},
+		{
+			name:    "invalid zip",
+			enabled: true,
+
+			format:  ArchiveZip,
+			archive: []byte("not a zip"),
+
+			wantErr:  true,
+			expected: ErrInvalidArchive,
+		},
+		{
+			name:    "unsupported format",
+			enabled: true,
+
+			format: "rar",
+
+			wantErr:  true,
+			expected: ErrUnsupportedArchive,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			var (
+				entries []ArchiveEntry
+				bodies  []string
+			)
+
+			err := Extract(test.format, bytes.NewReader(test.archive), t.TempDir(),
+				func(e ArchiveEntry, r io.Reader) (err error) {
+					b, err := ioutil.ReadAll(r)
+					entries, bodies = append(entries, e), append(bodies, string(b))
+
+					return err
+				})
+			if (err != nil) != test.wantErr {
+				t.Fatal(err)
+			}
+
+			if test.wantErr {
+				assert.True(t, errors.Is(err, test.expected))
+
+				return
+			}
+
+			if !assert.Len(t, entries, len(archiveFiles)) {
+				t.FailNow()
+			}
+
+			for i, f := range archiveFiles {
+				assert.Equal(t, ArchiveEntry{Name: f.name, Size: int64(len(f.body))}, entries[i])
+				assert.Equal(t, f.body, bodies[i])
+			}
+		})
+	}
+}
+
+func TestExtract_SkipContent(t *testing.T) {
+	var names []string
+
+	err := ExtractTar(bytes.NewReader(tarArchive(t)), func(e ArchiveEntry, r io.Reader) (err error) {
+		names = append(names, e.Name)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{"data/a.csv", "README.md", "data/empty.txt"}, names)
+}
+
+func TestArchiveFormat(t *testing.T) {
+	assert.Equal(t, ArchiveZip, ArchiveFormat("application/zip", "/bundle"))
+	assert.Equal(t, ArchiveZip, ArchiveFormat("application/octet-stream", "/bundle.ZIP"))
+	assert.Equal(t, ArchiveTar, ArchiveFormat("application/gzip", "/bundle.tar.gz"))
+}
+
+func TestCleanEntryName(t *testing.T) {
+	assert.Equal(t, "etc/passwd", cleanEntryName("../../etc/passwd"))
+	assert.Equal(t, "dir/file", cleanEntryName("./dir\\file"))
+}
@@ -0,0 +1,219 @@
+package transform
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+const (
+	CompressionGzip = "gzip"
+	CompressionZlib = "zlib"
+	CompressionZstd = "zstd"
+)
+
+var (
+	ErrUnknownCompression      = errors.New("compress error: unknown compression algorithm")
+	ErrInvalidCompressionLevel = errors.New("compress error: invalid compression level")
+)
+
+// compressedContentTypes are not compressed again because it makes them bigger more often than smaller.
+var compressedContentTypes = map[string]struct{}{
+	"application/gzip":             {},
+	"application/x-gzip":           {},
+	"application/zip":              {},
+	"application/zstd":             {},
+	"application/x-bzip2":          {},
+	"application/x-xz":             {},
+	"application/x-7z-compressed":  {},
+	"application/x-rar-compressed": {},
+	"application/vnd.rar":          {},
+	"application/x-compress":       {},
+	"image/jpeg":                   {},
+	"image/png":                    {},
+	"image/gif":                    {},
+	"image/webp":                   {},
+}
+
+type CompressorStats struct {
+	RawBytes        int64
+	CompressedBytes int64
+	IsSkipped       bool
+}
+
+// Compressor compresses the data with gzip, zlib or zstd. The compression is skipped if the content type of the
+// data says it is already compressed.
+type Compressor struct {
+	wrapper
+
+	algorithm string
+	level     int
+
+	c        *counter
+	w        io.WriteCloser
+	rawBytes int64
+	skipped  bool
+	isClosed bool
+}
+
+// NewCompressor creates compressor. Zero level means the default level of the algorithm, gzip and zlib levels are
+// 1-9, zstd levels are 1-22.
+func NewCompressor(s afd.Streamer, algorithm string, level int) (*Compressor, error) {
+	if err := ValidateCompression(algorithm, level); err != nil {
+		return nil, err
+	}
+
+	return &Compressor{
+		wrapper: wrapper{s: s},
+
+		algorithm: algorithm,
+		level:     level,
+
+		c: &counter{s: s},
+	}, nil
+}
+
+func ValidateCompression(algorithm string, level int) (err error) {
+	maxLevel := 0
+
+	switch algorithm {
+	case CompressionGzip, CompressionZlib:
+		maxLevel = gzip.BestCompression
+	case CompressionZstd:
+		maxLevel = 22
+	default:
+		return ErrUnknownCompression
+	}
+
+	if level < 0 || level > maxLevel {
+		return ErrInvalidCompressionLevel
+	}
+
+	return nil
+}
+
+func (c *Compressor) WriteHeader(m afd.Metadata) (err error) {
+	if c.w != nil || c.skipped {
+		return c.writeHeader(m)
+	}
+
+	if c.skipped = IsCompressed(m); !c.skipped {
+		if err = c.start(); err != nil {
+			return err
+		}
+
+		m.ContentEncoding, m.ContentLength = c.algorithm, -1
+	}
+
+	return c.writeHeader(m)
+}
+
+func (c *Compressor) Write(p []byte) (n int, err error) {
+	if c.skipped {
+		n, err = c.c.Write(p)
+		c.rawBytes += int64(n)
+
+		return n, err
+	}
+
+	if c.w == nil {
+		if err = c.start(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = c.w.Write(p)
+	c.rawBytes += int64(n)
+
+	return n, err
+}
+
+// Finish flushes the compressed stream, so the receiver gets complete stream only after successful download.
+func (c *Compressor) Finish() (err error) {
+	if c.w != nil {
+		c.isClosed = true
+
+		if err = c.w.Close(); err != nil {
+			return err
+		}
+	}
+
+	return c.finish()
+}
+
+// Close closes the output. If the stream was not finished, the encoder is closed too, so the zstd encoder stops its
+// goroutines and releases its buffers. The rest of the compressed data is dropped instead of being sent into the
+// output of the failed download.
+func (c *Compressor) Close() (err error) {
+	if c.w != nil && !c.isClosed {
+		c.isClosed = true
+
+		if r, ok := c.w.(interface{ Reset(w io.Writer) }); ok {
+			r.Reset(ioutil.Discard)
+		}
+
+		_ = c.w.Close()
+	}
+
+	return c.wrapper.Close()
+}
+
+func (c *Compressor) Stats() CompressorStats {
+	return CompressorStats{
+		RawBytes:        c.rawBytes,
+		CompressedBytes: c.c.n,
+		IsSkipped:       c.skipped,
+	}
+}
+
+func (c *Compressor) start() (err error) {
+	switch c.algorithm {
+	case CompressionGzip:
+		c.w, err = gzip.NewWriterLevel(c.c, defaultLevel(c.level, gzip.DefaultCompression))
+	case CompressionZlib:
+		c.w, err = zlib.NewWriterLevel(c.c, defaultLevel(c.level, zlib.DefaultCompression))
+	case CompressionZstd:
+		c.w, err = zstd.NewWriter(c.c, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(
+			defaultLevel(c.level, 3))))
+	default:
+		err = ErrUnknownCompression
+	}
+
+	return err
+}
+
+func defaultLevel(level int, defaultLevel int) int {
+	if level == 0 {
+		return defaultLevel
+	}
+
+	return level
+}
+
+// IsCompressed reports whether the data is already compressed according to its content type or encoding.
+func IsCompressed(m afd.Metadata) bool {
+	if m.ContentEncoding != "" && !strings.EqualFold(m.ContentEncoding, "identity") {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(m.ContentType)
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(mediaType, "video/") || strings.HasPrefix(mediaType, "audio/") {
+		return true
+	}
+
+	_, ok := compressedContentTypes[mediaType]
+
+	return ok
+}
@@ -0,0 +1,242 @@
+package transform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+type bufferStreamer struct {
+	bytes.Buffer
+
+	header     *afd.Metadata
+	isFinished bool
+}
+
+func (bs *bufferStreamer) WriteHeader(m afd.Metadata) (err error) {
+	bs.header = &m
+
+	return nil
+}
+
+func (bs *bufferStreamer) Finish() (err error) {
+	bs.isFinished = true
+
+	return nil
+}
+
+func (bs *bufferStreamer) Close() (err error) {
+	return nil
+}
+
+func TestCompressor(t *testing.T) {
+	body := bytes.Repeat([]byte(`{"key":"value"},`), 1024)
+
+	tt := []struct {
+		name    string
+		enabled bool
+
+		algorithm string
+		level     int
+		header    *afd.Metadata
+
+		decompress func(r io.Reader) (io.Reader, error)
+
+		expectedHeader  *afd.Metadata
+		expectedSkipped bool
+	}{
+		{
+			name:    "gzip",
+			enabled: true,
+
+			algorithm: CompressionGzip,
+			header:    &afd.Metadata{ContentType: "application/json", ContentLength: int64(len(body))},
+
+			decompress: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+
+			expectedHeader: &afd.Metadata{
+				ContentType:     "application/json",
+				ContentLength:   -1,
+				ContentEncoding: CompressionGzip,
+			},
+		},
+		{
+			name:    "zlib without header",
+			enabled: true,
+
+			algorithm: CompressionZlib,
+			level:     9,
+
+			decompress: func(r io.Reader) (io.Reader, error) {
+				return zlib.NewReader(r)
+			},
+		},
+		{
+			name:    "zstd",
+			enabled: true,
+
+			algorithm: CompressionZstd,
+			level:     19,
+			header:    &afd.Metadata{ContentType: "text/csv", ContentLength: int64(len(body))},
+
+			decompress: func(r io.Reader) (io.Reader, error) {
+				return zstd.NewReader(r)
+			},
+
+			expectedHeader: &afd.Metadata{
+				ContentType:     "text/csv",
+				ContentLength:   -1,
+				ContentEncoding: CompressionZstd,
+			},
+		},
+		{
+			name:    "already compressed",
+			enabled: true,
+
+			algorithm: CompressionGzip,
+			header:    &afd.Metadata{ContentType: "application/gzip", ContentLength: int64(len(body))},
+
+			decompress: func(r io.Reader) (io.Reader, error) {
+				return r, nil
+			},
+
+			expectedHeader:  &afd.Metadata{ContentType: "application/gzip", ContentLength: int64(len(body))},
+			expectedSkipped: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			buf := &bufferStreamer{}
+
+			c, err := NewCompressor(buf, test.algorithm, test.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.header != nil {
+				if err = c.WriteHeader(*test.header); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err = c.Write(body); err != nil {
+				t.Fatal(err)
+			}
+
+			if err = c.Finish(); err != nil {
+				t.Fatal(err)
+			}
+
+			stats := c.Stats()
+			compressedBytes := int64(buf.Len())
+
+			r, err := test.decompress(&buf.Buffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, body, actual)
+			assert.Equal(t, test.expectedHeader, buf.header)
+			assert.True(t, buf.isFinished)
+			assert.Equal(t, CompressorStats{
+				RawBytes:        int64(len(body)),
+				CompressedBytes: compressedBytes,
+				IsSkipped:       test.expectedSkipped,
+			}, stats)
+		})
+	}
+}
+
+func TestCompressor_Close(t *testing.T) {
+	for _, algorithm := range []string{CompressionGzip, CompressionZlib, CompressionZstd} {
+		t.Run(algorithm, func(t *testing.T) {
+			buf := &bufferStreamer{}
+
+			c, err := NewCompressor(buf, algorithm, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = c.Write(bytes.Repeat([]byte(`{"key":"value"},`), 1024)); err != nil {
+				t.Fatal(err)
+			}
+
+			n := buf.Len()
+
+			if err = c.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.True(t, c.isClosed)
+			assert.Equal(t, n, buf.Len())
+			assert.False(t, buf.isFinished)
+		})
+	}
+}
+
+func TestValidateCompression(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		algorithm string
+		level     int
+
+		expected error
+	}{
+		{
+			name:    "pass",
+			enabled: true,
+
+			algorithm: CompressionZstd,
+			level:     22,
+		},
+		{
+			name:    "unknown algorithm",
+			enabled: true,
+
+			algorithm: "lz4",
+
+			expected: ErrUnknownCompression,
+		},
+		{
+			name:    "invalid level",
+			enabled: true,
+
+			algorithm: CompressionGzip,
+			level:     10,
+
+			expected: ErrInvalidCompressionLevel,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			assert.Equal(t, test.expected, ValidateCompression(test.algorithm, test.level))
+		})
+	}
+}
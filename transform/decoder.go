@@ -0,0 +1,171 @@
+package transform
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingIdentity = "identity"
+	EncodingGzip     = "gzip"
+	EncodingDeflate  = "deflate"
+	EncodingBrotli   = "br"
+	EncodingZstd     = "zstd"
+)
+
+var ErrUnsupportedEncoding = errors.New("decode error: unsupported encoding")
+
+// payloadContentTypes maps content types of compressed files to their encodings.
+var payloadContentTypes = map[string]string{
+	"application/gzip":   EncodingGzip,
+	"application/x-gzip": EncodingGzip,
+	"application/zstd":   EncodingZstd,
+	"application/x-zstd": EncodingZstd,
+	"application/x-br":   EncodingBrotli,
+}
+
+// payloadExtensions maps extensions of compressed files to their encodings.
+var payloadExtensions = map[string]string{
+	".gz":  EncodingGzip,
+	".zst": EncodingZstd,
+	".br":  EncodingBrotli,
+}
+
+// Decoder decodes the data which was encoded with one or more encodings.
+type Decoder struct {
+	r       io.Reader
+	closers []io.Closer
+	applied []string
+}
+
+// NewDecoder creates decoder for the encodings listed in the order they were applied, as in Content-Encoding header.
+// Identity encoding is skipped.
+func NewDecoder(r io.Reader, encodings []string) (d *Decoder, err error) {
+	d = &Decoder{r: r}
+
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := normalizeEncoding(encodings[i])
+		if encoding == EncodingIdentity {
+			continue
+		}
+
+		if err = d.push(encoding); err != nil {
+			_ = d.Close()
+
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+func (d *Decoder) push(encoding string) (err error) {
+	switch encoding {
+	case EncodingGzip:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(d.r); err != nil {
+			return err
+		}
+
+		d.r, d.closers = zr, append(d.closers, zr)
+	case EncodingDeflate:
+		var zr io.ReadCloser
+		if zr, err = zlib.NewReader(d.r); err != nil {
+			return err
+		}
+
+		d.r, d.closers = zr, append(d.closers, zr)
+	case EncodingBrotli:
+		d.r = brotli.NewReader(d.r)
+	case EncodingZstd:
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(d.r); err != nil {
+			return err
+		}
+
+		d.r, d.closers = zr, append(d.closers, zstdCloser{zr})
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+	}
+
+	d.applied = append(d.applied, encoding)
+
+	return nil
+}
+
+func (d *Decoder) Read(p []byte) (n int, err error) {
+	return d.r.Read(p)
+}
+
+// Applied returns the encodings which were decoded in the order they were decoded.
+func (d *Decoder) Applied() []string {
+	return d.applied
+}
+
+// Close releases decoders. The underlying reader is not closed.
+func (d *Decoder) Close() (err error) {
+	for i := len(d.closers) - 1; i >= 0; i-- {
+		if closeErr := d.closers[i].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// zstdCloser adapts zstd decoder, which Close does not return error.
+type zstdCloser struct {
+	d *zstd.Decoder
+}
+
+func (c zstdCloser) Close() (err error) {
+	c.d.Close()
+
+	return nil
+}
+
+// ParseContentEncoding splits Content-Encoding header value into the list of encodings.
+func ParseContentEncoding(s string) (encodings []string) {
+	for _, encoding := range strings.Split(s, ",") {
+		if encoding = normalizeEncoding(encoding); encoding != "" {
+			encodings = append(encodings, encoding)
+		}
+	}
+
+	return encodings
+}
+
+// PayloadEncoding returns the encoding of the compressed file according to its content type or, if the content type
+// is generic, extension of the path. Empty string means the payload is not a compressed file.
+func PayloadEncoding(contentType string, filePath string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if encoding, ok := payloadContentTypes[mediaType]; ok {
+			return encoding
+		}
+
+		if mediaType != "application/octet-stream" {
+			return ""
+		}
+	}
+
+	return payloadExtensions[strings.ToLower(path.Ext(filePath))]
+}
+
+func normalizeEncoding(encoding string) string {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+
+	if encoding == "x-gzip" {
+		return EncodingGzip
+	}
+
+	return encoding
+}
@@ -0,0 +1,217 @@
+package transform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecoder(t *testing.T) {
+	body := bytes.Repeat([]byte(`id,name\n1,value\n`), 1024)
+
+	gzipEncode := func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	}
+	deflateEncode := func(w io.Writer) (io.WriteCloser, error) {
+		return zlib.NewWriter(w), nil
+	}
+	brotliEncode := func(w io.Writer) (io.WriteCloser, error) {
+		return brotli.NewWriter(w), nil
+	}
+	zstdEncode := func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w)
+	}
+
+	tt := []struct {
+		name    string
+		enabled bool
+
+		encodings []string
+		encoders  []func(w io.Writer) (io.WriteCloser, error)
+
+		wantErr         bool
+		expectedErr     error
+		expectedApplied []string
+	}{
+		{
+			name:    "gzip",
+			enabled: true,
+
+			encodings: []string{"x-gzip"},
+			encoders:  []func(w io.Writer) (io.WriteCloser, error){gzipEncode},
+
+			expectedApplied: []string{EncodingGzip},
+		},
+		{
+			name:    "deflate",
+			enabled: true,
+
+			encodings: []string{EncodingDeflate},
+			encoders:  []func(w io.Writer) (io.WriteCloser, error){deflateEncode},
+
+			expectedApplied: []string{EncodingDeflate},
+		},
+		{
+			name:    "brotli",
+			enabled: true,
+
+			encodings: []string{EncodingBrotli},
+			encoders:  []func(w io.Writer) (io.WriteCloser, error){brotliEncode},
+
+			expectedApplied: []string{EncodingBrotli},
+		},
+		{
+			name:    "zstd",
+			enabled: true,
+
+			encodings: []string{EncodingZstd},
+			encoders:  []func(w io.Writer) (io.WriteCloser, error){zstdEncode},
+
+			expectedApplied: []string{EncodingZstd},
+		},
+		{
+			name:    "several encodings",
+			enabled: true,
+
+			encodings: []string{EncodingGzip, EncodingIdentity, EncodingBrotli},
+			encoders:  []func(w io.Writer) (io.WriteCloser, error){gzipEncode, brotliEncode},
+
+			expectedApplied: []string{EncodingBrotli, EncodingGzip},
+		},
+		{
+			name:    "identity",
+			enabled: true,
+
+			encodings: []string{EncodingIdentity},
+		},
+		{
+			name:    "unsupported encoding",
+			enabled: true,
+
+			encodings: []string{"compress"},
+
+			wantErr:     true,
+			expectedErr: ErrUnsupportedEncoding,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			data := body
+			for _, encode := range test.encoders {
+				buf := new(bytes.Buffer)
+
+				w, err := encode(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err = w.Write(data); err != nil {
+					t.Fatal(err)
+				}
+
+				if err = w.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				data = buf.Bytes()
+			}
+
+			d, err := NewDecoder(bytes.NewReader(data), test.encodings)
+			if (err != nil) != test.wantErr {
+				t.Fatal(err)
+			}
+
+			if test.wantErr {
+				assert.True(t, errors.Is(err, test.expectedErr))
+
+				return
+			}
+
+			defer d.Close()
+
+			actual, err := ioutil.ReadAll(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, body, actual)
+			assert.Equal(t, test.expectedApplied, d.Applied())
+		})
+	}
+}
+
+func TestPayloadEncoding(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		contentType string
+		path        string
+
+		expected string
+	}{
+		{
+			name:    "content type",
+			enabled: true,
+
+			contentType: "application/x-gzip",
+			path:        "/data",
+
+			expected: EncodingGzip,
+		},
+		{
+			name:    "extension with generic content type",
+			enabled: true,
+
+			contentType: "application/octet-stream",
+			path:        "/data.csv.ZST",
+
+			expected: EncodingZstd,
+		},
+		{
+			name:    "extension without content type",
+			enabled: true,
+
+			path: "/data.json.br",
+
+			expected: EncodingBrotli,
+		},
+		{
+			name:    "specific content type",
+			enabled: true,
+
+			contentType: "text/csv",
+			path:        "/data.csv.gz",
+		},
+		{
+			name:    "not compressed",
+			enabled: true,
+
+			contentType: "application/octet-stream",
+			path:        "/data.csv",
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			assert.Equal(t, test.expected, PayloadEncoding(test.contentType, test.path))
+		})
+	}
+}
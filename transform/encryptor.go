@@ -0,0 +1,140 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"filippo.io/age"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+// EncryptionAge is the content coding of the data encrypted into age format.
+const EncryptionAge = "age"
+
+var (
+	ErrNoRecipients     = errors.New("encrypt error: no recipients")
+	ErrInvalidRecipient = errors.New("encrypt error: invalid recipient")
+)
+
+type EncryptorStats struct {
+	RawBytes       int64
+	EncryptedBytes int64
+}
+
+// Encryptor encrypts the data into age format, https://age-encryption.org/v1, for X25519 recipients. The data is
+// split into chunks which are encrypted with ChaCha20-Poly1305, so it is encrypted on the fly and the truncated data
+// could not be decrypted.
+type Encryptor struct {
+	wrapper
+
+	recipients []age.Recipient
+
+	c        *counter
+	w        io.WriteCloser
+	rawBytes int64
+}
+
+// NewEncryptor creates encryptor for the recipients public keys, e.g. "age1...".
+func NewEncryptor(s afd.Streamer, recipients []string) (*Encryptor, error) {
+	parsed, err := ParseRecipients(recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Encryptor{
+		wrapper: wrapper{s: s},
+
+		recipients: parsed,
+
+		c: &counter{s: s},
+	}, nil
+}
+
+func ParseRecipients(recipients []string) (parsed []age.Recipient, err error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	parsed = make([]age.Recipient, 0, len(recipients))
+
+	for _, recipient := range recipients {
+		r, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRecipient, err)
+		}
+
+		parsed = append(parsed, r)
+	}
+
+	return parsed, nil
+}
+
+// WriteHeader passes the header to the wrapped streamer before the encryption starts, because age writes its own
+// header into the streamer as soon as it is started.
+func (e *Encryptor) WriteHeader(m afd.Metadata) (err error) {
+	if m.ContentEncoding == "" {
+		m.ContentEncoding = EncryptionAge
+	} else {
+		m.ContentEncoding += ", " + EncryptionAge
+	}
+
+	m.ContentLength = -1
+
+	if err = e.writeHeader(m); err != nil {
+		return err
+	}
+
+	if e.w == nil {
+		return e.start()
+	}
+
+	return nil
+}
+
+func (e *Encryptor) Write(p []byte) (n int, err error) {
+	if e.w == nil {
+		if err = e.start(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = e.w.Write(p)
+	e.rawBytes += int64(n)
+
+	return n, err
+}
+
+// Finish writes the last chunk, so the receiver could decrypt the data only after successful download.
+func (e *Encryptor) Finish() (err error) {
+	if e.w == nil {
+		if err = e.start(); err != nil {
+			return err
+		}
+	}
+
+	if err = e.w.Close(); err != nil {
+		return err
+	}
+
+	return e.finish()
+}
+
+func (e *Encryptor) Stats() EncryptorStats {
+	return EncryptorStats{
+		RawBytes:       e.rawBytes,
+		EncryptedBytes: e.c.n,
+	}
+}
+
+func (e *Encryptor) start() (err error) {
+	e.w, err = age.Encrypt(e.c, e.recipients...)
+
+	return err
+}
+
+// NewDecryptor returns reader of the data decrypted from age format with one of the identities.
+func NewDecryptor(r io.Reader, identities []age.Identity) (io.Reader, error) {
+	return age.Decrypt(r, identities...)
+}
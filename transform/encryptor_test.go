@@ -0,0 +1,210 @@
+package transform
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+func TestEncryptor(t *testing.T) {
+	var (
+		body = bytes.Repeat([]byte(`{"key":"value"},`), 8192)
+
+		identities = make([]*age.X25519Identity, 2)
+	)
+
+	for i := range identities {
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		identities[i] = identity
+	}
+
+	tt := []struct {
+		name    string
+		enabled bool
+
+		recipients []string
+		identity   age.Identity
+		header     *afd.Metadata
+
+		expectedHeader *afd.Metadata
+	}{
+		{
+			name:    "one recipient",
+			enabled: true,
+
+			recipients: []string{identities[0].Recipient().String()},
+			identity:   identities[0],
+			header:     &afd.Metadata{ContentType: "text/csv", ContentLength: int64(len(body))},
+
+			expectedHeader: &afd.Metadata{ContentType: "text/csv", ContentLength: -1, ContentEncoding: EncryptionAge},
+		},
+		{
+			name:    "several recipients",
+			enabled: true,
+
+			recipients: []string{identities[0].Recipient().String(), identities[1].Recipient().String()},
+			identity:   identities[1],
+			header:     &afd.Metadata{ContentType: "text/csv", ContentLength: -1, ContentEncoding: EncodingGzip},
+
+			expectedHeader: &afd.Metadata{
+				ContentType:     "text/csv",
+				ContentLength:   -1,
+				ContentEncoding: EncodingGzip + ", " + EncryptionAge,
+			},
+		},
+		{
+			name:    "without header",
+			enabled: true,
+
+			recipients: []string{identities[0].Recipient().String()},
+			identity:   identities[0],
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			buf := &bufferStreamer{}
+
+			e, err := NewEncryptor(buf, test.recipients)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.header != nil {
+				if err = e.WriteHeader(*test.header); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err = e.Write(body); err != nil {
+				t.Fatal(err)
+			}
+
+			if err = e.Finish(); err != nil {
+				t.Fatal(err)
+			}
+
+			stats := e.Stats()
+			encryptedBytes := int64(buf.Len())
+
+			r, err := NewDecryptor(&buf.Buffer, []age.Identity{test.identity})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, body, actual)
+			assert.Equal(t, test.expectedHeader, buf.header)
+			assert.True(t, buf.isFinished)
+			assert.Equal(t, EncryptorStats{
+				RawBytes:       int64(len(body)),
+				EncryptedBytes: encryptedBytes,
+			}, stats)
+		})
+	}
+}
+
+// headerFirstStreamer fails the header which is written after the data, as the framed stream does.
+type headerFirstStreamer struct {
+	bufferStreamer
+}
+
+func (s *headerFirstStreamer) WriteHeader(m afd.Metadata) (err error) {
+	if s.Len() > 0 {
+		return errors.New("header after data")
+	}
+
+	return s.bufferStreamer.WriteHeader(m)
+}
+
+func TestEncryptor_HeaderWriter(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &headerFirstStreamer{}
+
+	e, err := NewEncryptor(s, []string{identity.Recipient().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = e.WriteHeader(afd.Metadata{ContentType: "text/csv", ContentLength: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = e.Write([]byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = e.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewDecryptor(&s.Buffer, []age.Identity{identity})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []byte(`{}`), actual)
+	assert.Equal(t, &afd.Metadata{ContentType: "text/csv", ContentLength: -1, ContentEncoding: EncryptionAge},
+		s.header)
+}
+
+func TestEncryptor_Truncated(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bufferStreamer{}
+
+	e, err := NewEncryptor(buf, []string{identity.Recipient().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = e.Write(bytes.Repeat([]byte(`a`), 128*1024)); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewDecryptor(&buf.Buffer, []age.Identity{identity})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ioutil.ReadAll(r)
+	assert.Error(t, err)
+}
+
+func TestParseRecipients(t *testing.T) {
+	_, err := ParseRecipients(nil)
+	assert.Equal(t, ErrNoRecipients, err)
+
+	_, err = ParseRecipients([]string{"age1invalid"})
+	assert.True(t, errors.Is(err, ErrInvalidRecipient))
+}
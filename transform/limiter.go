@@ -0,0 +1,192 @@
+package transform
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+// Bucket is the token bucket which limits the rate of bytes. It is safe for concurrent use, so one bucket could limit
+// several streams together.
+type Bucket struct {
+	mu sync.Mutex
+
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) (err error)
+}
+
+// NewBucket creates bucket which allows rate bytes per second on average and burst bytes at once. The rate should be
+// positive, zero burst means the rate. The bucket is full at start.
+func NewBucket(rate int64, burst int) *Bucket {
+	if burst <= 0 {
+		burst = int(math.Min(float64(rate), math.MaxInt32))
+	}
+
+	if burst <= 0 {
+		burst = 1
+	}
+
+	return &Bucket{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+
+		now:   time.Now,
+		sleep: sleep,
+	}
+}
+
+// Burst returns the max number of bytes which could be taken at once.
+func (b *Bucket) Burst() int {
+	return b.burst
+}
+
+// Wait takes n bytes from the bucket, waiting until they are available. The bytes are reserved before waiting, so
+// concurrent callers are served in order.
+func (b *Bucket) Wait(ctx context.Context, n int) (err error) {
+	b.mu.Lock()
+
+	now := b.now()
+
+	b.tokens = math.Min(float64(b.burst), b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+
+	wait := time.Duration(0)
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+
+	b.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	return b.sleep(ctx, wait)
+}
+
+func sleep(ctx context.Context, d time.Duration) (err error) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitAll takes n bytes from every bucket, nil buckets are skipped.
+func waitAll(ctx context.Context, buckets []*Bucket, n int) (err error) {
+	for _, b := range buckets {
+		if b == nil {
+			continue
+		}
+
+		if err = b.Wait(ctx, n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// maxChunk returns the smallest burst of the buckets, so a single chunk does not exceed any of them.
+func maxChunk(buckets []*Bucket, n int) int {
+	for _, b := range buckets {
+		if b != nil && b.Burst() < n {
+			n = b.Burst()
+		}
+	}
+
+	return n
+}
+
+// LimitedReader limits the rate of reading from the wrapped reader.
+type LimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	buckets []*Bucket
+}
+
+func NewLimitedReader(ctx context.Context, r io.Reader, buckets ...*Bucket) *LimitedReader {
+	return &LimitedReader{
+		ctx:     ctx,
+		r:       r,
+		buckets: buckets,
+	}
+}
+
+// Read reads at most the smallest burst of the buckets and waits for the bytes which were read.
+func (lr *LimitedReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if n, err = lr.r.Read(p[:maxChunk(lr.buckets, len(p))]); n == 0 {
+		return n, err
+	}
+
+	if waitErr := waitAll(lr.ctx, lr.buckets, n); waitErr != nil {
+		return n, waitErr
+	}
+
+	return n, err
+}
+
+// Limiter limits the rate of writing into the wrapped streamer.
+type Limiter struct {
+	wrapper
+
+	ctx     context.Context
+	buckets []*Bucket
+}
+
+func NewLimiter(ctx context.Context, s afd.Streamer, buckets ...*Bucket) *Limiter {
+	return &Limiter{
+		wrapper: wrapper{s: s},
+
+		ctx:     ctx,
+		buckets: buckets,
+	}
+}
+
+func (l *Limiter) WriteHeader(m afd.Metadata) (err error) {
+	return l.writeHeader(m)
+}
+
+// Write splits p into chunks not bigger than the smallest burst of the buckets and waits before writing each chunk.
+func (l *Limiter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := maxChunk(l.buckets, len(p))
+
+		if err = waitAll(l.ctx, l.buckets, chunk); err != nil {
+			return n, err
+		}
+
+		written, err := l.s.Write(p[:chunk])
+		if n += written; err != nil {
+			return n, err
+		}
+
+		p = p[chunk:]
+	}
+
+	return n, nil
+}
+
+func (l *Limiter) Finish() (err error) {
+	return l.finish()
+}
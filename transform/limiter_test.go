@@ -0,0 +1,159 @@
+package transform
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+// fakeClock moves the time of the bucket forward on sleep instead of sleeping.
+type fakeClock struct {
+	mu    sync.Mutex
+	t     time.Time
+	slept []time.Duration
+}
+
+func newFakeBucket(rate int64, burst int, isAdvance bool) (*Bucket, *fakeClock) {
+	var (
+		b = NewBucket(rate, burst)
+		c = &fakeClock{t: time.Unix(0, 0)}
+	)
+
+	b.last = c.t
+	b.now = func() time.Time {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		return c.t
+	}
+	b.sleep = func(_ context.Context, d time.Duration) (err error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if isAdvance {
+			c.t = c.t.Add(d)
+		}
+
+		c.slept = append(c.slept, d)
+
+		return nil
+	}
+
+	return b, c
+}
+
+func TestBucket_Wait(t *testing.T) {
+	b, c := newFakeBucket(100, 50, true)
+
+	for _, n := range []int{50, 50, 25} {
+		if err := b.Wait(context.Background(), n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, 250 * time.Millisecond}, c.slept)
+	assert.Equal(t, time.Unix(0, 0).Add(750*time.Millisecond), c.t)
+}
+
+func TestBucket_WaitConcurrent(t *testing.T) {
+	var (
+		b, c = newFakeBucket(100, 50, false)
+		wg   sync.WaitGroup
+	)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := b.Wait(context.Background(), 50); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	assert.ElementsMatch(t, []time.Duration{
+		500 * time.Millisecond,
+		1000 * time.Millisecond,
+		1500 * time.Millisecond,
+	}, c.slept)
+}
+
+func TestBucket_WaitCanceled(t *testing.T) {
+	b := NewBucket(1, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.NoError(t, b.Wait(ctx, 1))
+	assert.Equal(t, context.Canceled, b.Wait(ctx, 1))
+}
+
+func TestLimitedReader(t *testing.T) {
+	var (
+		body = bytes.Repeat([]byte(`a`), 1000)
+
+		job, jobClock       = newFakeBucket(100, 100, true)
+		global, globalClock = newFakeBucket(1000, 40, true)
+	)
+
+	actual, err := ioutil.ReadAll(NewLimitedReader(context.Background(), bytes.NewReader(body), job, global))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, body, actual)
+
+	jobTotal := time.Duration(0)
+	for _, d := range jobClock.slept {
+		jobTotal += d
+	}
+
+	globalTotal := time.Duration(0)
+	for _, d := range globalClock.slept {
+		globalTotal += d
+	}
+
+	// The job bucket allows 100 bytes at once and 100 bytes per second, so 900 bytes wait for 9 seconds.
+	assert.Equal(t, 9*time.Second, jobTotal.Round(time.Millisecond))
+	assert.Equal(t, 960*time.Millisecond, globalTotal.Round(time.Millisecond))
+}
+
+func TestLimiter(t *testing.T) {
+	var (
+		body = bytes.Repeat([]byte(`a`), 250)
+		buf  = &bufferStreamer{}
+
+		b, c = newFakeBucket(100, 100, true)
+		l    = NewLimiter(context.Background(), buf, b)
+	)
+
+	if err := l.WriteHeader(afd.Metadata{ContentLength: int64(len(body))}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := l.Write(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = l.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(body), n)
+	assert.Equal(t, body, buf.Bytes())
+	assert.Equal(t, &afd.Metadata{ContentLength: int64(len(body))}, buf.header)
+	assert.True(t, buf.isFinished)
+	assert.Equal(t, []time.Duration{time.Second, 500 * time.Millisecond}, c.slept)
+}
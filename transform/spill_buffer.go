@@ -0,0 +1,255 @@
+package transform
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+type SpillBufferStats struct {
+	PeakMemoryBytes int
+	PeakSpillBytes  int64
+	SpilledBytes    int64
+}
+
+// SpillBuffer decouples the writer from the reader. The data is kept in the in-memory ring buffer and, when the ring
+// buffer is full, is written into the temporary file until the reader drains it, so the writer does not wait for the
+// slow reader. The writer waits only if the size of the file reaches the max spill size.
+type SpillBuffer struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	ring []byte
+	head int
+	size int
+
+	dir          string
+	file         *os.File
+	readOffset   int64
+	writeOffset  int64
+	maxSpillSize int64
+	isSpilling   bool
+
+	isWriteClosed bool
+	writeErr      error
+	readErr       error
+
+	stats SpillBufferStats
+}
+
+// NewSpillBuffer creates buffer with the ring buffer of memorySize bytes. The temporary file is created in dir, or
+// in the default directory for temporary files if dir is empty, on the first spill. Zero maxSpillSize means the size
+// of the file is not limited.
+func NewSpillBuffer(memorySize int, maxSpillSize int64, dir string) *SpillBuffer {
+	sb := &SpillBuffer{
+		ring: make([]byte, memorySize),
+
+		dir:          dir,
+		maxSpillSize: maxSpillSize,
+	}
+
+	sb.cond = sync.NewCond(&sb.mu)
+
+	return sb
+}
+
+// Write puts p into the ring buffer or, if it is full, into the file.
+func (sb *SpillBuffer) Write(p []byte) (n int, err error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	for len(p) > 0 {
+		if sb.readErr != nil {
+			return n, sb.readErr
+		}
+
+		if sb.isWriteClosed {
+			return n, io.ErrClosedPipe
+		}
+
+		var written int
+
+		switch {
+		case !sb.isSpilling && sb.size < len(sb.ring):
+			written = sb.writeRing(p)
+		case sb.maxSpillSize > 0 && sb.writeOffset-sb.readOffset >= sb.maxSpillSize:
+			sb.cond.Wait()
+
+			continue
+		default:
+			if written, err = sb.writeFile(p); err != nil {
+				return n, err
+			}
+		}
+
+		n, p = n+written, p[written:]
+
+		sb.cond.Broadcast()
+	}
+
+	return n, nil
+}
+
+func (sb *SpillBuffer) writeRing(p []byte) (n int) {
+	for len(p) > 0 && sb.size < len(sb.ring) {
+		tail := (sb.head + sb.size) % len(sb.ring)
+
+		end := len(sb.ring)
+		if tail < sb.head {
+			end = sb.head
+		}
+
+		copied := copy(sb.ring[tail:end], p)
+		sb.size, n, p = sb.size+copied, n+copied, p[copied:]
+	}
+
+	if sb.size > sb.stats.PeakMemoryBytes {
+		sb.stats.PeakMemoryBytes = sb.size
+	}
+
+	return n
+}
+
+func (sb *SpillBuffer) writeFile(p []byte) (n int, err error) {
+	if sb.file == nil {
+		if sb.file, err = os.CreateTemp(sb.dir, "afd-spill-*"); err != nil {
+			return 0, err
+		}
+	}
+
+	if free := sb.maxSpillSize - (sb.writeOffset - sb.readOffset); sb.maxSpillSize > 0 && int64(len(p)) > free {
+		p = p[:free]
+	}
+
+	n, err = sb.file.WriteAt(p, sb.writeOffset)
+	sb.writeOffset += int64(n)
+	sb.stats.SpilledBytes += int64(n)
+	sb.isSpilling = true
+
+	if spilled := sb.writeOffset - sb.readOffset; spilled > sb.stats.PeakSpillBytes {
+		sb.stats.PeakSpillBytes = spilled
+	}
+
+	return n, err
+}
+
+// CloseWrite ends the data. The reader gets the error, or io.EOF after all data if the error is nil.
+func (sb *SpillBuffer) CloseWrite(err error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	sb.isWriteClosed, sb.writeErr = true, err
+
+	sb.cond.Broadcast()
+}
+
+// Read gets the data from the ring buffer and then from the file in the order it was written.
+func (sb *SpillBuffer) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	for sb.size == 0 && sb.readOffset == sb.writeOffset {
+		if sb.readErr != nil {
+			return 0, sb.readErr
+		}
+
+		if sb.writeErr != nil {
+			return 0, sb.writeErr
+		}
+
+		if sb.isWriteClosed {
+			return 0, io.EOF
+		}
+
+		sb.cond.Wait()
+	}
+
+	if sb.writeErr != nil {
+		return 0, sb.writeErr
+	}
+
+	defer sb.cond.Broadcast()
+
+	// The ring buffer always has older data than the file, because the file is written only when the ring buffer
+	// is full and until the file is drained.
+	if sb.size > 0 {
+		return sb.readRing(p), nil
+	}
+
+	return sb.readFile(p)
+}
+
+func (sb *SpillBuffer) readRing(p []byte) (n int) {
+	end := sb.head + sb.size
+	if end > len(sb.ring) {
+		end = len(sb.ring)
+	}
+
+	n = copy(p, sb.ring[sb.head:end])
+	sb.head, sb.size = (sb.head+n)%len(sb.ring), sb.size-n
+
+	return n
+}
+
+func (sb *SpillBuffer) readFile(p []byte) (n int, err error) {
+	if left := sb.writeOffset - sb.readOffset; int64(len(p)) > left {
+		p = p[:left]
+	}
+
+	n, err = sb.file.ReadAt(p, sb.readOffset)
+	if sb.readOffset += int64(n); sb.readOffset == sb.writeOffset {
+		sb.readOffset, sb.writeOffset, sb.isSpilling = 0, 0, false
+	}
+
+	if errors.Is(err, io.EOF) && n == len(p) {
+		err = nil
+	}
+
+	return n, err
+}
+
+// CloseRead stops the reading. The writer gets the error.
+func (sb *SpillBuffer) CloseRead(err error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if err == nil {
+		err = io.ErrClosedPipe
+	}
+
+	sb.readErr = err
+
+	sb.cond.Broadcast()
+}
+
+func (sb *SpillBuffer) Stats() SpillBufferStats {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	return sb.stats
+}
+
+// Close removes the temporary file. The buffer should not be used after that.
+func (sb *SpillBuffer) Close() (err error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if sb.file == nil {
+		return nil
+	}
+
+	err = sb.file.Close()
+
+	if removeErr := os.Remove(sb.file.Name()); removeErr != nil && err == nil {
+		err = removeErr
+	}
+
+	sb.file = nil
+
+	return err
+}
@@ -0,0 +1,171 @@
+package transform
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpillBuffer(t *testing.T) {
+	body := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(body)
+
+	tt := []struct {
+		name    string
+		enabled bool
+
+		memorySize   int
+		maxSpillSize int64
+		readDelay    time.Duration
+
+		expectedSpilled bool
+	}{
+		{
+			name:    "memory only",
+			enabled: true,
+
+			memorySize: len(body),
+
+			expectedSpilled: false,
+		},
+		{
+			name:    "spill",
+			enabled: true,
+
+			memorySize: 1024,
+			readDelay:  time.Millisecond,
+
+			expectedSpilled: true,
+		},
+		{
+			name:    "limited spill",
+			enabled: true,
+
+			memorySize:   1024,
+			maxSpillSize: 4096,
+			readDelay:    time.Millisecond,
+
+			expectedSpilled: true,
+		},
+		{
+			name:    "without memory",
+			enabled: true,
+
+			expectedSpilled: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			var (
+				dir  = t.TempDir()
+				sb   = NewSpillBuffer(test.memorySize, test.maxSpillSize, dir)
+				done = make(chan error, 1)
+			)
+
+			go func() {
+				var err error
+
+				for p := body; len(p) > 0 && err == nil; {
+					chunk := 1 + rand.Intn(3000)
+					if chunk > len(p) {
+						chunk = len(p)
+					}
+
+					_, err = sb.Write(p[:chunk])
+					p = p[chunk:]
+				}
+
+				sb.CloseWrite(err)
+				done <- err
+			}()
+
+			actual := new(bytes.Buffer)
+			p := make([]byte, 2048)
+
+			for {
+				n, err := sb.Read(p)
+				actual.Write(p[:n])
+
+				if errors.Is(err, io.EOF) {
+					break
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				time.Sleep(test.readDelay)
+			}
+
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+
+			stats := sb.Stats()
+
+			assert.Equal(t, body, actual.Bytes())
+			assert.Equal(t, test.expectedSpilled, stats.SpilledBytes > 0)
+			assert.LessOrEqual(t, stats.PeakMemoryBytes, test.memorySize)
+
+			if test.maxSpillSize > 0 {
+				assert.LessOrEqual(t, stats.PeakSpillBytes, test.maxSpillSize)
+			}
+
+			assert.NoError(t, sb.Close())
+
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Empty(t, files)
+		})
+	}
+}
+
+func TestSpillBuffer_CloseRead(t *testing.T) {
+	var (
+		sb       = NewSpillBuffer(4, 4, t.TempDir())
+		expected = errors.New("write error")
+	)
+
+	defer sb.Close()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		sb.CloseRead(expected)
+	}()
+
+	// The ring buffer and the file are full, so the writer waits until the reader fails.
+	_, err := sb.Write(make([]byte, 16))
+	assert.Equal(t, expected, err)
+}
+
+func TestSpillBuffer_CloseWriteError(t *testing.T) {
+	var (
+		sb       = NewSpillBuffer(4, 0, t.TempDir())
+		expected = errors.New("read error")
+	)
+
+	defer sb.Close()
+
+	if _, err := sb.Write([]byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	sb.CloseWrite(expected)
+
+	_, err := sb.Read(make([]byte, 4))
+	assert.Equal(t, expected, err)
+}
@@ -0,0 +1,213 @@
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+var ErrInvalidChunkSize = errors.New("split error: chunk size should be positive")
+
+// ChunkHeader is sent as a JSON line before the data of every chunk. Total is zero if the size of the data is
+// unknown, then the chunks are listed only in the manifest.
+type ChunkHeader struct {
+	Index     int    `json:"index"`
+	Total     int    `json:"total"`
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+	RequestID string `json:"request-id,omitempty"`
+}
+
+// Chunk describes the chunk which was delivered.
+type Chunk struct {
+	Index  int
+	Output string
+	Offset int64
+	Bytes  int64
+	SHA256 string
+	Report interface{}
+}
+
+// ChunkCreator creates the streamer for the chunk with the index and returns the output it is connected to.
+type ChunkCreator func(index int) (s afd.Streamer, output string, err error)
+
+// Splitter cuts the data into chunks of the fixed size and delivers every chunk with its own streamer. The
+// streamer of the chunk is created when the first byte of the chunk is written, finished and closed when the chunk
+// is full.
+type Splitter struct {
+	create ChunkCreator
+	size   int64
+
+	m     afd.Metadata
+	total int
+
+	current afd.Streamer
+	chunk   Chunk
+	hash    hash.Hash
+	offset  int64
+
+	chunks []Chunk
+}
+
+func NewSplitter(size int64, create ChunkCreator) (*Splitter, error) {
+	if size <= 0 {
+		return nil, ErrInvalidChunkSize
+	}
+
+	return &Splitter{
+		create: create,
+		size:   size,
+
+		m: afd.Metadata{ContentLength: -1},
+	}, nil
+}
+
+// WriteHeader keeps the metadata which is sent with every chunk. The number of chunks is known if the content
+// length is known.
+func (sp *Splitter) WriteHeader(m afd.Metadata) (err error) {
+	sp.m = m
+
+	if m.ContentLength >= 0 {
+		sp.total = int((m.ContentLength + sp.size - 1) / sp.size)
+	}
+
+	return nil
+}
+
+func (sp *Splitter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if sp.current == nil {
+			if err = sp.open(); err != nil {
+				return n, err
+			}
+		}
+
+		chunk := p
+		if left := sp.size - sp.chunk.Bytes; int64(len(chunk)) > left {
+			chunk = chunk[:left]
+		}
+
+		written, err := sp.current.Write(chunk)
+		_, _ = sp.hash.Write(chunk[:written])
+		sp.chunk.Bytes += int64(written)
+		sp.offset += int64(written)
+
+		if n += written; err != nil {
+			return n, err
+		}
+
+		if p = p[written:]; sp.chunk.Bytes == sp.size {
+			if err = sp.complete(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// Finish completes the last chunk. The empty data is delivered as one empty chunk.
+func (sp *Splitter) Finish() (err error) {
+	if sp.current == nil && len(sp.chunks) == 0 {
+		if err = sp.open(); err != nil {
+			return err
+		}
+	}
+
+	if sp.current == nil {
+		return nil
+	}
+
+	return sp.complete()
+}
+
+// Chunks returns the manifest of the delivered chunks.
+func (sp *Splitter) Chunks() []Chunk {
+	return sp.chunks
+}
+
+// Close closes the streamer of the incomplete chunk.
+func (sp *Splitter) Close() (err error) {
+	if sp.current == nil {
+		return nil
+	}
+
+	err, sp.current = sp.current.Close(), nil
+
+	return err
+}
+
+func (sp *Splitter) open() (err error) {
+	var (
+		index = len(sp.chunks)
+		size  = int64(-1)
+	)
+
+	if sp.m.ContentLength >= 0 {
+		if size = sp.m.ContentLength - sp.offset; size > sp.size {
+			size = sp.size
+		}
+	}
+
+	prefix, err := json.Marshal(ChunkHeader{
+		Index:     index,
+		Total:     sp.total,
+		Offset:    sp.offset,
+		Size:      size,
+		RequestID: sp.m.RequestID,
+	})
+	if err != nil {
+		return err
+	}
+
+	prefix = append(prefix, '\n')
+
+	s, output, err := sp.create(index)
+	if err != nil {
+		return err
+	}
+
+	sp.current, sp.hash = s, sha256.New()
+	sp.chunk = Chunk{Index: index, Output: output, Offset: sp.offset}
+
+	if hw, ok := s.(afd.HeaderWriter); ok {
+		m := sp.m
+		if m.ContentLength = -1; size >= 0 {
+			m.ContentLength = int64(len(prefix)) + size
+		}
+
+		if err = hw.WriteHeader(m); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.Write(prefix)
+
+	return err
+}
+
+func (sp *Splitter) complete() (err error) {
+	if f, ok := sp.current.(afd.Finisher); ok {
+		if err = f.Finish(); err != nil {
+			return err
+		}
+	}
+
+	if r, ok := sp.current.(afd.Reporter); ok {
+		sp.chunk.Report = r.Report()
+	}
+
+	sp.chunk.SHA256 = hex.EncodeToString(sp.hash.Sum(nil))
+
+	if err = sp.Close(); err != nil {
+		return err
+	}
+
+	sp.chunks = append(sp.chunks, sp.chunk)
+
+	return nil
+}
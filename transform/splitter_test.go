@@ -0,0 +1,201 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+type chunkStreamer struct {
+	bytes.Buffer
+
+	m        afd.Metadata
+	isClosed bool
+}
+
+func (cs *chunkStreamer) WriteHeader(m afd.Metadata) (err error) {
+	cs.m = m
+
+	return nil
+}
+
+func (cs *chunkStreamer) Close() (err error) {
+	cs.isClosed = true
+
+	return nil
+}
+
+func TestSplitter(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		body          string
+		contentLength int64
+		size          int64
+
+		expectedChunks []string
+		expectedTotal  int
+	}{
+		{
+			name:    "known length",
+			enabled: true,
+
+			body:          "0123456789",
+			contentLength: 10,
+			size:          4,
+
+			expectedChunks: []string{"0123", "4567", "89"},
+			expectedTotal:  3,
+		},
+		{
+			name:    "unknown length",
+			enabled: true,
+
+			body:          "0123456789",
+			contentLength: -1,
+			size:          5,
+
+			expectedChunks: []string{"01234", "56789"},
+		},
+		{
+			name:    "empty",
+			enabled: true,
+
+			contentLength: 0,
+			size:          4,
+
+			expectedChunks: []string{""},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			var streamers []*chunkStreamer
+
+			sp, err := NewSplitter(test.size, func(index int) (afd.Streamer, string, error) {
+				s := &chunkStreamer{}
+				streamers = append(streamers, s)
+
+				return s, fmt.Sprintf("127.0.0.1:%d", 5000+index), nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = sp.WriteHeader(afd.Metadata{ContentLength: test.contentLength, RequestID: "id"}); err != nil {
+				t.Fatal(err)
+			}
+
+			// Writes by 3 bytes, so writes cross the bounds of the chunks.
+			for body := []byte(test.body); len(body) > 0; {
+				n := 3
+				if n > len(body) {
+					n = len(body)
+				}
+
+				if _, err = sp.Write(body[:n]); err != nil {
+					t.Fatal(err)
+				}
+
+				body = body[n:]
+			}
+
+			if err = sp.Finish(); err != nil {
+				t.Fatal(err)
+			}
+
+			if !assert.Len(t, streamers, len(test.expectedChunks)) {
+				t.FailNow()
+			}
+
+			var offset int64
+
+			for i, s := range streamers {
+				r := bufio.NewReader(&s.Buffer)
+
+				line, err := r.ReadBytes('\n')
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var header ChunkHeader
+				if err = json.Unmarshal(line, &header); err != nil {
+					t.Fatal(err)
+				}
+
+				data, _ := ioutil.ReadAll(r)
+				sum := sha256.Sum256(data)
+
+				size := int64(-1)
+				if test.contentLength >= 0 {
+					size = int64(len(test.expectedChunks[i]))
+				}
+
+				assert.Equal(t, ChunkHeader{
+					Index:     i,
+					Total:     test.expectedTotal,
+					Offset:    offset,
+					Size:      size,
+					RequestID: "id",
+				}, header)
+				assert.Equal(t, test.expectedChunks[i], string(data))
+				assert.True(t, s.isClosed)
+				assert.Equal(t, Chunk{
+					Index:  i,
+					Output: fmt.Sprintf("127.0.0.1:%d", 5000+i),
+					Offset: offset,
+					Bytes:  int64(len(data)),
+					SHA256: hex.EncodeToString(sum[:]),
+				}, sp.Chunks()[i])
+
+				if size >= 0 {
+					assert.Equal(t, int64(len(line))+size, s.m.ContentLength)
+				}
+
+				offset += int64(len(data))
+			}
+		})
+	}
+}
+
+func TestSplitter_CreateError(t *testing.T) {
+	createErr := errors.New("dial network error")
+
+	sp, err := NewSplitter(2, func(index int) (afd.Streamer, string, error) {
+		if index == 1 {
+			return nil, "", createErr
+		}
+
+		return &chunkStreamer{}, "127.0.0.1:5000", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := sp.Write([]byte("0123"))
+
+	assert.True(t, errors.Is(err, createErr))
+	assert.Equal(t, 2, n)
+	assert.Len(t, sp.Chunks(), 1)
+}
+
+func TestNewSplitter_InvalidSize(t *testing.T) {
+	_, err := NewSplitter(0, nil)
+
+	assert.True(t, errors.Is(err, ErrInvalidChunkSize))
+}
@@ -0,0 +1,52 @@
+// Package transform contains streamers which change the data on the way to the wrapped streamer.
+package transform
+
+import (
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+// wrapper forwards optional streamer interfaces to the wrapped streamer.
+type wrapper struct {
+	s afd.Streamer
+}
+
+func (w wrapper) writeHeader(m afd.Metadata) (err error) {
+	if hw, ok := w.s.(afd.HeaderWriter); ok {
+		return hw.WriteHeader(m)
+	}
+
+	return nil
+}
+
+func (w wrapper) finish() (err error) {
+	if f, ok := w.s.(afd.Finisher); ok {
+		return f.Finish()
+	}
+
+	return nil
+}
+
+func (w wrapper) Report() (report interface{}) {
+	if r, ok := w.s.(afd.Reporter); ok {
+		return r.Report()
+	}
+
+	return nil
+}
+
+func (w wrapper) Close() (err error) {
+	return w.s.Close()
+}
+
+// counter counts bytes written into the wrapped streamer.
+type counter struct {
+	s afd.Streamer
+	n int64
+}
+
+func (c *counter) Write(p []byte) (n int, err error) {
+	n, err = c.s.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
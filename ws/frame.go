@@ -0,0 +1,153 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Opcodes of the frames, https://www.rfc-editor.org/rfc/rfc6455#section-5.2.
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Close codes, https://www.rfc-editor.org/rfc/rfc6455#section-7.4.1.
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseNoStatus      = 1005
+	CloseInternalError = 1011
+)
+
+const (
+	maxControlPayload = 125
+
+	// maxIgnoredPayload limits the size of the data frames from the server which are read and dropped.
+	maxIgnoredPayload = 1 << 20
+)
+
+var ErrInvalidFrame = errors.New("ws error: invalid frame")
+
+// appendFrame appends the frame with the payload to b. The payload is masked with the random key, as the client
+// should do.
+func appendFrame(b []byte, opcode byte, payload []byte) ([]byte, error) {
+	b = append(b, 0x80|opcode)
+
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, 0x80|byte(n))
+	case n <= 0xFFFF:
+		b = append(b, 0x80|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 0x80|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, err
+	}
+
+	b = append(b, key[:]...)
+
+	for i, c := range payload {
+		b = append(b, c^key[i%4])
+	}
+
+	return b, nil
+}
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// readFrame reads the frame from the server. The payload of data frames bigger than maxIgnoredPayload is skipped,
+// because the data from the server is not used.
+func readFrame(r io.Reader) (f frame, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return f, err
+	}
+
+	f.fin, f.opcode = header[0]&0x80 != 0, header[0]&0x0F
+
+	var (
+		isMasked = header[1]&0x80 != 0
+		n        = uint64(header[1] & 0x7F)
+	)
+
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return f, err
+		}
+
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return f, err
+		}
+
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if f.opcode >= OpClose && (n > maxControlPayload || !f.fin) {
+		return f, fmt.Errorf("%w: control frame %#x", ErrInvalidFrame, f.opcode)
+	}
+
+	var key [4]byte
+	if isMasked {
+		if _, err = io.ReadFull(r, key[:]); err != nil {
+			return f, err
+		}
+	}
+
+	if n > maxIgnoredPayload {
+		_, err = io.CopyN(io.Discard, r, int64(n))
+
+		return f, err
+	}
+
+	f.payload = make([]byte, n)
+	if _, err = io.ReadFull(r, f.payload); err != nil {
+		return f, err
+	}
+
+	if isMasked {
+		for i := range f.payload {
+			f.payload[i] ^= key[i%4]
+		}
+	}
+
+	return f, nil
+}
+
+// closePayload returns the payload of the close frame with the code and the reason.
+func closePayload(code int, reason string) []byte {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+// parseClosePayload returns the code of the close frame, CloseNoStatus if there is no code.
+func parseClosePayload(payload []byte) (code int, reason string) {
+	if len(payload) < 2 {
+		return CloseNoStatus, ""
+	}
+
+	return int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+}
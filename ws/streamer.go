@@ -0,0 +1,584 @@
+package ws
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1" // nolint: gosec
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+const (
+	Scheme       = "ws://"
+	SecureScheme = "wss://"
+
+	DefaultFrameSize    = 32 * 1024
+	DefaultPingInterval = 30 * time.Second
+	DefaultCloseTimeout = 5 * time.Second
+
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var (
+	ErrInvalidAddress   = errors.New("ws error: address should be ws://host/path or wss://host/path")
+	ErrHandshakeFailed  = errors.New("ws error: handshake failed")
+	ErrClosedByPeer     = errors.New("ws error: connection closed by peer")
+	ErrPongTimeout      = errors.New("ws error: no pong from peer")
+	ErrAbnormalClosure  = errors.New("ws error: peer closed connection with error code")
+	ErrNoCloseFrame     = errors.New("ws error: peer did not answer the close frame")
+	errStreamerFinished = errors.New("ws error: streamer finished")
+)
+
+type Options struct {
+	// FrameSize is the max size of the payload of the binary message. Zero means DefaultFrameSize.
+	FrameSize int
+
+	// PingInterval is the interval between pings, the connection fails if the pong is not received until the next
+	// ping. Zero means DefaultPingInterval, negative disables pings.
+	PingInterval time.Duration
+
+	// CloseTimeout limits waiting for the close frame of the peer on Finish. Zero means DefaultCloseTimeout.
+	CloseTimeout time.Duration
+
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// Headers are sent with the handshake request, e.g. Authorization.
+	Headers map[string]string
+
+	// TLSConfig is used for wss://. Nil means the default config.
+	TLSConfig *tls.Config
+}
+
+type Report struct {
+	CloseCode int   `json:"close-code"`
+	Messages  int64 `json:"messages"`
+	Bytes     int64 `json:"bytes"`
+}
+
+// Streamer sends the data into WebSocket connection, https://www.rfc-editor.org/rfc/rfc6455, as binary messages
+// of the frame size. The metadata is sent as text message with JSON before the data. Finish completes the transfer
+// with the normal close code, Close without Finish closes the connection with the internal error code.
+type Streamer struct {
+	conn net.Conn
+	opts Options
+
+	// mu serializes writing frames, because pings and pongs are sent from other goroutines.
+	mu          sync.Mutex
+	buf         []byte
+	out         []byte
+	isCloseSent bool
+
+	// state is protected by stateMu, it is changed by the reading goroutine.
+	stateMu       sync.Mutex
+	err           error
+	isPongWaiting bool
+	peerCode      int
+
+	// control queues pings, pongs and the close echo for writeControl, so neither the reading goroutine nor the ping
+	// watchdog waits for mu while Write is blocked on the peer which does not read.
+	control chan frame
+
+	peerClosed chan struct{}
+	stop       chan struct{}
+	done       sync.WaitGroup
+
+	isClosed bool
+	report   Report
+}
+
+func NewStreamer(address string, opts Options) (s *Streamer, err error) {
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		return nil, ErrInvalidAddress
+	}
+
+	if opts.FrameSize <= 0 {
+		opts.FrameSize = DefaultFrameSize
+	}
+
+	if opts.PingInterval == 0 {
+		opts.PingInterval = DefaultPingInterval
+	}
+
+	if opts.CloseTimeout <= 0 {
+		opts.CloseTimeout = DefaultCloseTimeout
+	}
+
+	conn, err := dial(u, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	br, err := handshake(conn, u, opts)
+	if err != nil {
+		_ = conn.Close()
+
+		return nil, err
+	}
+
+	s = &Streamer{
+		conn: conn,
+		opts: opts,
+
+		buf: make([]byte, 0, opts.FrameSize),
+
+		control: make(chan frame, 2),
+
+		peerClosed: make(chan struct{}),
+		stop:       make(chan struct{}),
+	}
+
+	s.done.Add(2)
+
+	go s.read(br)
+	go s.writeControl()
+
+	if opts.PingInterval > 0 {
+		s.done.Add(1)
+
+		go s.ping()
+	}
+
+	return s, nil
+}
+
+func dial(u *url.URL, opts Options) (conn net.Conn, err error) {
+	var (
+		d    = &net.Dialer{Timeout: opts.DialTimeout}
+		host = u.Host
+	)
+
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	if u.Scheme == "ws" {
+		return d.Dial("tcp", host)
+	}
+
+	config := opts.TLSConfig
+	if config == nil {
+		config = &tls.Config{} // nolint: gosec
+	}
+
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = u.Hostname()
+	}
+
+	return tls.DialWithDialer(d, "tcp", host, config)
+}
+
+// handshake sends the opening handshake and checks the answer of the server.
+func handshake(conn net.Conn, u *url.URL, opts Options) (br *bufio.Reader, err error) {
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+
+	for name, value := range opts.Headers {
+		req.Header.Set(name, value)
+	}
+
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	if opts.DialTimeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(opts.DialTimeout))
+		defer conn.SetDeadline(time.Time{}) // nolint: errcheck
+	}
+
+	if err = req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	br = bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHandshakeFailed, err)
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%w: %s", ErrHandshakeFailed, resp.Status)
+	}
+
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
+		!headerContains(resp.Header, "Connection", "upgrade") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, fmt.Errorf("%w: invalid upgrade response", ErrHandshakeFailed)
+	}
+
+	return br, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID)) // nolint: gosec
+
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name string, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// WriteHeader sends the metadata as text message.
+func (s *Streamer) WriteHeader(m afd.Metadata) (err error) {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.writeFrame(OpText, payload)
+}
+
+// Write fills the binary message up to the frame size, full messages are sent.
+func (s *Streamer) Write(p []byte) (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(p) > 0 {
+		copied := copy(s.buf[len(s.buf):cap(s.buf)], p)
+		s.buf, n, p = s.buf[:len(s.buf)+copied], n+copied, p[copied:]
+
+		if len(s.buf) < cap(s.buf) {
+			break
+		}
+
+		if err = s.flush(); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// Finish sends the rest of the data and the close frame with the normal code, then waits for the close frame of
+// the peer. The transfer fails if the peer does not answer with the close frame until CloseTimeout, the peer could
+// have lost the data then.
+func (s *Streamer) Finish() (err error) {
+	s.mu.Lock()
+
+	if err = s.flush(); err == nil {
+		err = s.writeClose(CloseNormal, "")
+	}
+
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	t := time.NewTimer(s.opts.CloseTimeout)
+	defer t.Stop()
+
+	isTimeout := false
+
+	select {
+	case <-s.peerClosed:
+	case <-t.C:
+		isTimeout = true
+	}
+
+	s.shutdown()
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	if isTimeout {
+		return fmt.Errorf("%w: timeout %s", ErrNoCloseFrame, s.opts.CloseTimeout)
+	}
+
+	if s.peerCode == 0 {
+		return ErrNoCloseFrame
+	}
+
+	if s.peerCode != CloseNormal && s.peerCode != CloseNoStatus && s.peerCode != 0 {
+		return fmt.Errorf("%w: %d", ErrAbnormalClosure, s.peerCode)
+	}
+
+	return nil
+}
+
+func (s *Streamer) Report() interface{} {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	report := s.report
+	report.CloseCode = s.peerCode
+
+	return report
+}
+
+// Close closes the connection. If the streamer was not finished, the close frame with the internal error code is
+// sent before that.
+func (s *Streamer) Close() (err error) {
+	if s.isClosed {
+		return nil
+	}
+
+	s.mu.Lock()
+	_ = s.writeClose(CloseInternalError, "transfer interrupted")
+	s.mu.Unlock()
+
+	s.shutdown()
+
+	return nil
+}
+
+func (s *Streamer) shutdown() {
+	if s.isClosed {
+		return
+	}
+
+	s.isClosed = true
+
+	s.setErr(errStreamerFinished)
+	close(s.stop)
+	_ = s.conn.Close()
+
+	s.done.Wait()
+}
+
+// flush sends the buffered data as binary message. The caller should hold mu.
+func (s *Streamer) flush() (err error) {
+	if len(s.buf) == 0 {
+		return nil
+	}
+
+	if err = s.writeFrame(OpBinary, s.buf); err != nil {
+		return err
+	}
+
+	s.stateMu.Lock()
+	s.report.Messages++
+	s.report.Bytes += int64(len(s.buf))
+	s.stateMu.Unlock()
+
+	s.buf = s.buf[:0]
+
+	return nil
+}
+
+// writeClose sends the close frame once. The caller should hold mu.
+func (s *Streamer) writeClose(code int, reason string) (err error) {
+	return s.writeClosePayload(closePayload(code, reason))
+}
+
+// writeClosePayload sends the close frame with the payload once. The caller should hold mu.
+func (s *Streamer) writeClosePayload(payload []byte) (err error) {
+	if s.isCloseSent {
+		return nil
+	}
+
+	s.isCloseSent = true
+
+	return s.writeFrame(OpClose, payload)
+}
+
+// writeFrame sends the frame. The caller should hold mu.
+func (s *Streamer) writeFrame(opcode byte, payload []byte) (err error) {
+	// The close frame of the peer fails the connection before it is echoed, the echo is sent anyway.
+	if err = s.error(); err != nil && !(opcode == OpClose && errors.Is(err, ErrClosedByPeer)) {
+		return err
+	}
+
+	if s.out, err = appendFrame(s.out[:0], opcode, payload); err != nil {
+		return err
+	}
+
+	if s.opts.WriteTimeout > 0 {
+		if err = s.conn.SetWriteDeadline(time.Now().Add(s.opts.WriteTimeout)); err != nil {
+			return err
+		}
+	}
+
+	if _, err = s.conn.Write(s.out); err != nil {
+		s.setErr(err)
+
+		// The connection could be closed by the ping watchdog, its error is more useful than the closed conn one.
+		return s.error()
+	}
+
+	return nil
+}
+
+// read handles the frames from the peer: answers pings, notes pongs and the close frame. The data is dropped.
+func (s *Streamer) read(br *bufio.Reader) {
+	defer s.done.Done()
+
+	for {
+		f, err := readFrame(br)
+		if err != nil {
+			s.setErr(err)
+			s.closePeer()
+
+			return
+		}
+
+		switch f.opcode {
+		case OpPing:
+			s.sendControl(frame{opcode: OpPong, payload: f.payload})
+		case OpPong:
+			s.stateMu.Lock()
+			s.isPongWaiting = false
+			s.stateMu.Unlock()
+		case OpClose:
+			code, reason := parseClosePayload(f.payload)
+
+			s.stateMu.Lock()
+			s.peerCode = code
+			s.stateMu.Unlock()
+
+			// The close frame of the peer is echoed, unless the streamer has already sent its own. The close frame
+			// without the status is echoed empty, because CloseNoStatus must not be sent on the wire. The echo is
+			// queued, because Write could hold mu.
+			echo := frame{opcode: OpClose}
+			if code != CloseNoStatus {
+				echo.payload = closePayload(code, "")
+			}
+
+			select {
+			case s.control <- echo:
+			case <-s.stop:
+			}
+
+			s.setErr(fmt.Errorf("%w: %d %s", ErrClosedByPeer, code, reason))
+			s.closePeer()
+
+			return
+		}
+	}
+}
+
+func (s *Streamer) closePeer() {
+	select {
+	case <-s.peerClosed:
+	default:
+		close(s.peerClosed)
+	}
+}
+
+// sendControl queues the control frame without blocking. The frame is dropped if the queue is full, the peer
+// fails the pong check anyway if it does not read.
+func (s *Streamer) sendControl(f frame) {
+	select {
+	case s.control <- f:
+	default:
+	}
+}
+
+// writeControl sends the queued pings, pongs and the close echo.
+func (s *Streamer) writeControl() {
+	defer s.done.Done()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case f := <-s.control:
+			s.mu.Lock()
+			if f.opcode == OpClose {
+				_ = s.writeClosePayload(f.payload)
+			} else {
+				_ = s.writeFrame(f.opcode, f.payload)
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// ping sends pings with the interval and fails the connection if the previous ping was not answered. The
+// connection is closed without mu, so Write blocked on the peer which does not read fails too.
+func (s *Streamer) ping() {
+	defer s.done.Done()
+
+	t := time.NewTicker(s.opts.PingInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+		}
+
+		s.stateMu.Lock()
+		isPongWaiting := s.isPongWaiting
+		s.isPongWaiting = true
+		s.stateMu.Unlock()
+
+		if isPongWaiting {
+			s.setErr(ErrPongTimeout)
+			_ = s.conn.Close()
+
+			return
+		}
+
+		s.sendControl(frame{opcode: OpPing})
+	}
+}
+
+// setErr keeps the first error which breaks the connection.
+func (s *Streamer) setErr(err error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *Streamer) error() error {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	return s.err
+}
@@ -0,0 +1,469 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	afd "github.com/morozovcookie/afifiledownloader"
+)
+
+// testServer is the in-process WebSocket server which keeps the received messages.
+type testServer struct {
+	mu sync.Mutex
+
+	isPing    bool
+	closeCode int
+	noPong    bool
+	noRead    bool
+	noClose   bool
+	isClose   bool
+
+	header    http.Header
+	texts     []string
+	binaries  [][]byte
+	pings     int
+	closeRecv int
+	closeRaw  []byte
+	done      chan struct{}
+	hold      chan struct{}
+}
+
+func newTestServer() *testServer {
+	return &testServer{closeCode: CloseNormal, done: make(chan struct{}), hold: make(chan struct{})}
+}
+
+func (ts *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer close(ts.done)
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	ts.mu.Lock()
+	ts.header = r.Header.Clone()
+	ts.mu.Unlock()
+
+	conn, brw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+	_ = brw.Flush()
+
+	if ts.isPing {
+		_, _ = conn.Write([]byte{0x80 | OpPing, 0x02, 'h', 'i'})
+	}
+
+	if ts.isClose {
+		_, _ = conn.Write([]byte{0x80 | OpClose, 0x00})
+	}
+
+	// The peer which does not read keeps the connection open until the test releases it.
+	if ts.noRead {
+		<-ts.hold
+
+		return
+	}
+
+	ts.serve(conn, brw.Reader)
+}
+
+func (ts *testServer) serve(conn net.Conn, br *bufio.Reader) {
+	for {
+		f, err := readFrame(br)
+		if err != nil {
+			return
+		}
+
+		ts.mu.Lock()
+
+		switch f.opcode {
+		case OpText:
+			ts.texts = append(ts.texts, string(f.payload))
+		case OpBinary:
+			ts.binaries = append(ts.binaries, f.payload)
+		case OpPing:
+			ts.pings++
+
+			if !ts.noPong {
+				_, _ = conn.Write(append([]byte{0x80 | OpPong, byte(len(f.payload))}, f.payload...))
+			}
+		case OpPong:
+			ts.texts = append(ts.texts, "pong:"+string(f.payload))
+		case OpClose:
+			ts.closeRecv, _ = parseClosePayload(f.payload)
+			ts.closeRaw = f.payload
+
+			// The peer which does not answer the close frame keeps the connection open until the test releases it.
+			if ts.noClose {
+				ts.mu.Unlock()
+				<-ts.hold
+
+				return
+			}
+
+			_, _ = conn.Write(append([]byte{0x80 | OpClose, 2}, closePayload(ts.closeCode, "")...))
+			ts.mu.Unlock()
+
+			return
+		}
+
+		ts.mu.Unlock()
+	}
+}
+
+func TestStreamer(t *testing.T) {
+	tt := []struct {
+		name    string
+		enabled bool
+
+		opts      Options
+		closeCode int
+		body      string
+		writes    int
+
+		wantErr  bool
+		expected error
+
+		expectedBinaries []string
+		expectedReport   Report
+	}{
+		{
+			name:    "pass",
+			enabled: true,
+
+			opts:      Options{FrameSize: 4, Headers: map[string]string{"Authorization": "Bearer token"}},
+			closeCode: CloseNormal,
+			body:      "0123456789",
+			writes:    3,
+
+			expectedBinaries: []string{"0123", "4567", "89"},
+			expectedReport:   Report{CloseCode: CloseNormal, Messages: 3, Bytes: 10},
+		},
+		{
+			name:    "peer error code",
+			enabled: true,
+
+			opts:      Options{FrameSize: 16},
+			closeCode: CloseInternalError,
+			body:      "0123456789",
+			writes:    1,
+
+			wantErr:  true,
+			expected: ErrAbnormalClosure,
+
+			expectedBinaries: []string{"0123456789"},
+			expectedReport:   Report{CloseCode: CloseInternalError, Messages: 1, Bytes: 10},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.enabled {
+				t.SkipNow()
+			}
+
+			ts := newTestServer()
+			ts.closeCode = test.closeCode
+
+			srv := httptest.NewServer(ts)
+			defer srv.Close()
+
+			s, err := NewStreamer("ws"+strings.TrimPrefix(srv.URL, "http")+"/stream?id=1", test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer s.Close()
+
+			if err = s.WriteHeader(afd.Metadata{SourceURL: "http://127.0.0.1/index.json", ContentLength: 10}); err != nil {
+				t.Fatal(err)
+			}
+
+			chunk := (len(test.body) + test.writes - 1) / test.writes
+			for body := test.body; len(body) > 0; {
+				n := chunk
+				if n > len(body) {
+					n = len(body)
+				}
+
+				if _, err = s.Write([]byte(body[:n])); err != nil {
+					t.Fatal(err)
+				}
+
+				body = body[n:]
+			}
+
+			err = s.Finish()
+			if (err != nil) != test.wantErr {
+				t.Fatal(err)
+			}
+
+			if test.wantErr {
+				assert.True(t, errors.Is(err, test.expected), err)
+			}
+
+			<-ts.done
+
+			var m afd.Metadata
+			if assert.Len(t, ts.texts, 1) {
+				assert.NoError(t, json.Unmarshal([]byte(ts.texts[0]), &m))
+			}
+
+			binaries := make([]string, 0, len(ts.binaries))
+			for _, b := range ts.binaries {
+				binaries = append(binaries, string(b))
+			}
+
+			assert.Equal(t, int64(10), m.ContentLength)
+			assert.Equal(t, test.expectedBinaries, binaries)
+			assert.Equal(t, CloseNormal, ts.closeRecv)
+			assert.Equal(t, test.expectedReport, s.Report())
+
+			for name, value := range test.opts.Headers {
+				assert.Equal(t, value, ts.header.Get(name))
+			}
+		})
+	}
+}
+
+func TestStreamer_PingPong(t *testing.T) {
+	ts := newTestServer()
+	ts.isPing = true
+
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	s, err := NewStreamer("ws"+strings.TrimPrefix(srv.URL, "http"), Options{PingInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err = s.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	<-ts.done
+
+	assert.GreaterOrEqual(t, ts.pings, 2)
+	assert.Equal(t, []string{"pong:hi"}, ts.texts)
+}
+
+func TestStreamer_PongTimeout(t *testing.T) {
+	ts := newTestServer()
+	ts.noPong = true
+
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	s, err := NewStreamer("ws"+strings.TrimPrefix(srv.URL, "http"), Options{
+		FrameSize:    1,
+		PingInterval: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	_, err = s.Write([]byte("0"))
+
+	assert.True(t, errors.Is(err, ErrPongTimeout), err)
+}
+
+func TestStreamer_PongTimeoutBlockedWrite(t *testing.T) {
+	ts := newTestServer()
+	ts.noRead = true
+
+	defer close(ts.hold)
+
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	s, err := NewStreamer("ws"+strings.TrimPrefix(srv.URL, "http"), Options{
+		FrameSize:    64 * 1024,
+		PingInterval: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	// The peer does not read, so Write blocks as soon as the socket buffers are full and only the pong watchdog
+	// could break it, there is no write timeout.
+	errc := make(chan error, 1)
+
+	go func() {
+		data := make([]byte, 1024*1024)
+
+		for {
+			if _, err := s.Write(data); err != nil {
+				errc <- err
+
+				return
+			}
+		}
+	}()
+
+	select {
+	case err = <-errc:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write is not interrupted by the pong timeout")
+	}
+
+	assert.True(t, errors.Is(err, ErrPongTimeout), err)
+}
+
+func TestStreamer_FinishWithoutCloseAnswer(t *testing.T) {
+	ts := newTestServer()
+	ts.noClose = true
+
+	defer close(ts.hold)
+
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	s, err := NewStreamer("ws"+strings.TrimPrefix(srv.URL, "http"), Options{CloseTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	if _, err = s.Write([]byte("01")); err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Finish()
+
+	assert.True(t, errors.Is(err, ErrNoCloseFrame), err)
+}
+
+func TestStreamer_CloseNoStatus(t *testing.T) {
+	ts := newTestServer()
+	ts.isClose = true
+
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	s, err := NewStreamer("ws"+strings.TrimPrefix(srv.URL, "http"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	<-ts.done
+
+	assert.Equal(t, CloseNoStatus, ts.closeRecv)
+	assert.Empty(t, ts.closeRaw)
+	assert.Equal(t, CloseNoStatus, s.Report().(Report).CloseCode)
+}
+
+func TestStreamer_CloseWithoutFinish(t *testing.T) {
+	ts := newTestServer()
+
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	s, err := NewStreamer("ws"+strings.TrimPrefix(srv.URL, "http"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.Write([]byte("01")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	<-ts.done
+
+	assert.Equal(t, CloseInternalError, ts.closeRecv)
+	assert.Empty(t, ts.binaries)
+}
+
+func TestStreamer_TLS(t *testing.T) {
+	ts := newTestServer()
+
+	srv := httptest.NewTLSServer(ts)
+	defer srv.Close()
+
+	s, err := NewStreamer("wss"+strings.TrimPrefix(srv.URL, "https"), Options{
+		TLSConfig: srv.Client().Transport.(*http.Transport).TLSClientConfig,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	if _, err = s.Write([]byte("01")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	<-ts.done
+
+	assert.Equal(t, [][]byte{[]byte("01")}, ts.binaries)
+}
+
+func TestNewStreamer_HandshakeError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	_, err := NewStreamer("ws"+strings.TrimPrefix(srv.URL, "http"), Options{})
+	assert.True(t, errors.Is(err, ErrHandshakeFailed), err)
+
+	_, err = NewStreamer("http://127.0.0.1:5000", Options{})
+	assert.True(t, errors.Is(err, ErrInvalidAddress), err)
+}
+
+func TestFrame(t *testing.T) {
+	for _, size := range []int{0, 125, 126, 0xFFFF, 0x10000} {
+		payload := bytes.Repeat([]byte{'a'}, size)
+
+		b, err := appendFrame(nil, OpBinary, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := readFrame(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.True(t, f.fin)
+		assert.Equal(t, byte(OpBinary), f.opcode)
+		assert.Equal(t, payload, f.payload)
+	}
+}